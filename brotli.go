@@ -0,0 +1,61 @@
+package brotli
+
+import (
+	"fmt"
+	"io"
+
+	"gopkg.in/kothar/brotli-go.v0/dec"
+	"gopkg.in/kothar/brotli-go.v0/enc"
+)
+
+// These constants are the compression levels accepted by NewWriterLevel,
+// named like those of compress/gzip and compress/flate. Levels from
+// BestSpeed to BestCompression map directly onto the Brotli quality setting.
+const (
+	BestSpeed          = 0
+	BestCompression    = 11
+	DefaultCompression = -1
+)
+
+// The quality used for DefaultCompression, which compresses better than gzip
+// at a similar speed
+const defaultQuality = 6
+
+// NewWriter returns a Writer which compresses data written to it with Brotli
+// at the default compression level, writing the result to w.
+//
+// It is the caller's responsibility to call Close on the Writer when done, to
+// write the end of the stream and release the native encoder state.
+func NewWriter(w io.Writer) *enc.BrotliWriter {
+	writer, _ := NewWriterLevel(w, DefaultCompression)
+	return writer
+}
+
+// NewWriterLevel is like NewWriter but specifies the compression level
+// instead of assuming DefaultCompression.
+//
+// The compression level can be DefaultCompression, or any integer value
+// between BestSpeed and BestCompression inclusive. The error returned will be
+// nil if the level is valid.
+func NewWriterLevel(w io.Writer, level int) (*enc.BrotliWriter, error) {
+	if level == DefaultCompression {
+		level = defaultQuality
+	}
+	if level < BestSpeed || level > BestCompression {
+		return nil, fmt.Errorf("brotli: invalid compression level: %d", level)
+	}
+
+	params := enc.NewBrotliParams()
+	params.SetQuality(level)
+	return enc.NewBrotliWriter(params, w), nil
+}
+
+// NewReader returns a Reader which decompresses the Brotli stream read from r.
+// The error is always nil, and is returned so that NewReader can replace
+// gzip.NewReader.
+//
+// It is the caller's responsibility to call Close on the Reader when done, to
+// release the native decoder state.
+func NewReader(r io.Reader) (*dec.BrotliReader, error) {
+	return dec.NewBrotliReader(r), nil
+}
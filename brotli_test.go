@@ -5,6 +5,7 @@ import (
 	"io"
 	"io/ioutil"
 	"testing"
+	"testing/iotest"
 
 	"gopkg.in/kothar/brotli-go.v0/dec"
 	"gopkg.in/kothar/brotli-go.v0/enc"
@@ -93,6 +94,206 @@ func TestRoundtrip(T *testing.T) {
 	}
 }
 
+// Run roundtrip using parallel compression
+func TestRoundtripParallel(T *testing.T) {
+	inputs := []string{
+		"testdata/empty",
+		"testdata/alice29.txt",
+		"testdata/asyoulik.txt",
+		"testdata/lcet10.txt",
+		"testdata/plrabn12.txt",
+		"testdata/random_org_10k.bin",
+		"testdata/x",
+	}
+
+	for _, file := range inputs {
+		input, err := ioutil.ReadFile(file)
+		if err != nil {
+			T.Error(err)
+		}
+
+		for _, lgwin := range []int{10, 15, 22} {
+			for _, quality := range []int{1, 6, 9, 11} {
+				T.Logf("Parallel roundtrip testing %s at quality %d with lgwin %d", file, quality, lgwin)
+
+				params := enc.NewBrotliParams()
+				params.SetQuality(quality)
+				params.SetLgwin(lgwin)
+				params.SetLgblock(16)
+
+				bro, err := enc.CompressBufferParallel(params, input, nil, 4)
+				if err != nil {
+					T.Error(err)
+				}
+				T.Logf("  Compressed from %d to %d bytes, %.1f%%", len(input), len(bro), (float32(len(bro))/float32(len(input)))*100)
+
+				testDecompressBuffer(input, bro, T)
+
+				testDecompressStream(input, bytes.NewReader(bro), T)
+			}
+		}
+	}
+}
+
+// Run roundtrip through the parallel stream writer
+func TestRoundtripParallelStream(T *testing.T) {
+	inputs := []string{
+		"testdata/empty",
+		"testdata/alice29.txt",
+		"testdata/lcet10.txt",
+		"testdata/random_org_10k.bin",
+		"testdata/x",
+	}
+
+	for _, file := range inputs {
+		input, err := ioutil.ReadFile(file)
+		if err != nil {
+			T.Error(err)
+		}
+
+		for _, lgwin := range []int{10, 16, 22} {
+			T.Logf("Parallel stream roundtrip testing %s with lgwin %d", file, lgwin)
+
+			params := enc.NewBrotliParams()
+			params.SetQuality(6)
+			params.SetLgwin(lgwin)
+			params.SetLgblock(16)
+
+			var buffer bytes.Buffer
+			writer := enc.NewParallelWriter(params, &buffer, 3)
+			if _, err := io.Copy(writer, iotest.HalfReader(bytes.NewReader(input))); err != nil {
+				T.Error(err)
+			}
+			if err := writer.Close(); err != nil {
+				T.Error(err)
+			}
+			T.Logf("  Compressed from %d to %d bytes", len(input), buffer.Len())
+
+			testDecompressStream(input, &buffer, T)
+		}
+	}
+}
+
+// Run roundtrip using io.Copy, which uses ReadFrom and WriteTo
+func TestRoundtripCopy(T *testing.T) {
+	inputs := []string{
+		"testdata/empty",
+		"testdata/alice29.txt",
+		"testdata/lcet10.txt",
+		"testdata/random_org_10k.bin",
+	}
+
+	for _, file := range inputs {
+		input, err := ioutil.ReadFile(file)
+		if err != nil {
+			T.Error(err)
+		}
+
+		T.Logf("Copy roundtrip testing %s", file)
+
+		params := enc.NewBrotliParams()
+		params.SetQuality(4)
+		params.SetLgblock(16)
+
+		// Write some data first, so that ReadFrom starts part-way into a block
+		var compressed bytes.Buffer
+		bwriter := enc.NewBrotliWriter(params, &compressed)
+		split := len(input) / 3
+		if _, err := bwriter.Write(input[:split]); err != nil {
+			T.Error(err)
+		}
+		n, err := io.Copy(bwriter, iotest.HalfReader(bytes.NewReader(input[split:])))
+		if err != nil {
+			T.Error(err)
+		}
+		if n != int64(len(input)-split) {
+			T.Errorf("  ReadFrom consumed %d bytes, expected %d", n, len(input)-split)
+		}
+		if err := bwriter.Close(); err != nil {
+			T.Error(err)
+		}
+
+		var decompressed bytes.Buffer
+		breader := dec.NewBrotliReader(&compressed)
+		n, err = io.Copy(&decompressed, breader)
+		if err != nil {
+			T.Error(err)
+		}
+		if n != int64(len(input)) {
+			T.Errorf("  WriteTo wrote %d bytes, expected %d", n, len(input))
+		}
+		breader.Close()
+
+		check("Copy roundtrip", input, decompressed.Bytes(), T)
+	}
+}
+
+// Run roundtrip through the compressing reader
+func TestRoundtripCompressingReader(T *testing.T) {
+	inputs := []string{
+		"testdata/empty",
+		"testdata/alice29.txt",
+		"testdata/random_org_10k.bin",
+	}
+
+	for _, file := range inputs {
+		input, err := ioutil.ReadFile(file)
+		if err != nil {
+			T.Error(err)
+		}
+
+		T.Logf("Compressing reader roundtrip testing %s", file)
+
+		reader := enc.NewCompressingReader(nil, bytes.NewReader(input))
+		testDecompressStream(input, reader, T)
+		if err := reader.Close(); err != nil {
+			T.Error(err)
+		}
+	}
+}
+
+// Run roundtrip through the compress/gzip-style API at each level
+func TestRoundtripLevels(T *testing.T) {
+	input, err := ioutil.ReadFile("testdata/alice29.txt")
+	if err != nil {
+		T.Fatal(err)
+	}
+
+	for level := DefaultCompression; level <= BestCompression; level++ {
+		T.Logf("Level roundtrip testing level %d", level)
+
+		var compressed bytes.Buffer
+		writer, err := NewWriterLevel(&compressed, level)
+		if err != nil {
+			T.Fatal(err)
+		}
+		if _, err := writer.Write(input); err != nil {
+			T.Error(err)
+		}
+		if err := writer.Close(); err != nil {
+			T.Error(err)
+		}
+
+		reader, err := NewReader(&compressed)
+		if err != nil {
+			T.Fatal(err)
+		}
+		decompressed, err := ioutil.ReadAll(reader)
+		if err != nil {
+			T.Error(err)
+		}
+		reader.Close()
+
+		check("Level roundtrip", input, decompressed, T)
+	}
+
+	for _, level := range []int{-2, 12} {
+		if _, err := NewWriterLevel(ioutil.Discard, level); err == nil {
+			T.Errorf("Expected error for invalid level %d", level)
+		}
+	}
+}
+
 // Run roundtrip with a custom dictionary
 func TestRoundtripDict(T *testing.T) {
 	inputs := []string{
@@ -129,6 +330,130 @@ func TestRoundtripDict(T *testing.T) {
 	}
 }
 
+// Run a stream roundtrip with a custom dictionary
+func TestRoundtripStreamDict(T *testing.T) {
+	input, err := ioutil.ReadFile("testdata/alice29.txt")
+	if err != nil {
+		T.Fatal(err)
+	}
+
+	// A dictionary larger than the sliding window
+	dict, err := ioutil.ReadFile("testdata/asyoulik.txt")
+	if err != nil {
+		T.Fatal(err)
+	}
+
+	for _, lgwin := range []int{16, 22} {
+		T.Logf("Roundtrip testing with dictionary at lgwin %d", lgwin)
+
+		params := enc.NewBrotliParams()
+		params.SetQuality(6)
+		params.SetLgwin(lgwin)
+
+		plain := testCompressBuffer(params, input, T)
+
+		buffer := new(bytes.Buffer)
+		writer := enc.NewBrotliWriterDict(params, dict, buffer)
+		if _, err := writer.Write(input); err != nil {
+			T.Error(err)
+		}
+		if err := writer.Close(); err != nil {
+			T.Error(err)
+		}
+
+		bro := buffer.Bytes()
+		if len(bro) >= len(plain) {
+			T.Errorf("  Compressing with a dictionary didn't reduce the size (%d >= %d)", len(bro), len(plain))
+		}
+
+		testDecompressBufferDict(input, bro, dict, T)
+
+		reader := dec.NewBrotliReaderDict(bytes.NewReader(bro), dict)
+		unbro, err := ioutil.ReadAll(reader)
+		if err != nil {
+			T.Error(err)
+		}
+		check("Stream decompress with dictionary", input, unbro, T)
+
+		// The dictionary is kept when the streams are reused
+		buffer.Reset()
+		writer.Reset(buffer)
+		if _, err := writer.Write(input); err != nil {
+			T.Error(err)
+		}
+		if err := writer.Close(); err != nil {
+			T.Error(err)
+		}
+		if !bytes.Equal(buffer.Bytes(), bro) {
+			T.Error("  Reset writer didn't give the same result")
+		}
+
+		reader.Close()
+		reader.Reset(bytes.NewReader(bro))
+		unbro, err = ioutil.ReadAll(reader)
+		if err != nil {
+			T.Error(err)
+		}
+		check("Reset stream decompress with dictionary", input, unbro, T)
+		reader.Close()
+	}
+}
+
+// Check that everything written before a flush can be decoded before the
+// stream is closed
+func TestStreamFlush(T *testing.T) {
+	input, err := ioutil.ReadFile("testdata/alice29.txt")
+	if err != nil {
+		T.Fatal(err)
+	}
+
+	for _, quality := range []int{0, 1, 6, 9, 11} {
+		T.Logf("Flush testing at quality %d", quality)
+
+		params := enc.NewBrotliParams()
+		params.SetQuality(quality)
+
+		buffer := new(bytes.Buffer)
+		writer := enc.NewBrotliWriter(params, buffer)
+		reader := dec.NewBrotliReader(buffer)
+
+		for pos, chunkSize := 0, 1; pos < len(input); chunkSize *= 3 {
+			chunk := input[pos:]
+			if len(chunk) > chunkSize {
+				chunk = chunk[:chunkSize]
+			}
+			pos += len(chunk)
+
+			if _, err := writer.Write(chunk); err != nil {
+				T.Fatal(err)
+			}
+			if err := writer.Flush(); err != nil {
+				T.Fatal(err)
+			}
+
+			// Read one byte at a time so the decoder output buffer fills up
+			decoded := make([]byte, len(chunk))
+			if _, err := io.ReadFull(iotest.OneByteReader(reader), decoded); err != nil {
+				T.Fatal(err)
+			}
+			check("Flushed stream decompress", chunk, decoded, T)
+		}
+
+		if err := writer.Close(); err != nil {
+			T.Fatal(err)
+		}
+
+		rest, err := ioutil.ReadAll(reader)
+		if err != nil {
+			T.Error(err)
+		}
+		if len(rest) != 0 {
+			T.Errorf("  Unexpected %d bytes after the last flush", len(rest))
+		}
+		reader.Close()
+	}
+}
+
 func testCompressBuffer(params *enc.BrotliParams, input []byte, T *testing.T) []byte {
 	// Test buffer compression
 	bro, err := enc.CompressBuffer(params, input, nil)
@@ -0,0 +1,156 @@
+//go:build go1.25
+// +build go1.25
+
+// Package brotligrpc implements a Brotli compressor for gRPC, and registers
+// it with the name "br" when imported:
+//
+//	import _ "gopkg.in/kothar/brotli-go.v0/brotligrpc"
+//
+// Clients can then compress requests with grpc.UseCompressor(brotligrpc.Name),
+// and servers will decompress them and compress their responses to match.
+//
+// The registered compressor uses DefaultQuality. To use another quality,
+// register a compressor from NewCompressor instead, during initialization:
+//
+//	func init() {
+//		c, err := brotligrpc.NewCompressor(7)
+//		if err != nil {
+//			log.Fatal(err)
+//		}
+//		encoding.RegisterCompressor(c)
+//	}
+//
+// The package is only built with Go 1.25 or later, as google.golang.org/grpc
+// requires.
+package brotligrpc // import "gopkg.in/kothar/brotli-go.v0/brotligrpc"
+
+import (
+	"io"
+	"sync"
+
+	"google.golang.org/grpc/encoding"
+
+	"gopkg.in/kothar/brotli-go.v0/dec"
+	"gopkg.in/kothar/brotli-go.v0/enc"
+)
+
+// Name is the name registered for the Brotli compressor.
+const Name = "br"
+
+// DefaultQuality is the compression quality of the compressor registered when
+// the package is imported. It compresses well while being fast enough for each
+// message.
+const DefaultQuality = 5
+
+func init() {
+	c, _ := NewCompressor(DefaultQuality)
+	encoding.RegisterCompressor(c)
+}
+
+// NewCompressor returns a Brotli compressor named Name which compresses with
+// the given quality, from 0 to 11. It replaces the default compressor when
+// registered with encoding.RegisterCompressor, which must only be called
+// during initialization.
+func NewCompressor(quality int) (encoding.Compressor, error) {
+	params, err := enc.NewParams(enc.WithQuality(quality))
+	if err != nil {
+		return nil, err
+	}
+
+	c := &compressor{}
+	c.writers.New = func() interface{} {
+		return enc.NewBrotliWriter(params, nil)
+	}
+	return c, nil
+}
+
+// Pools the native encoder and decoder state between messages
+type compressor struct {
+	writers sync.Pool
+	readers sync.Pool
+}
+
+func (c *compressor) Name() string {
+	return Name
+}
+
+func (c *compressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	encoder := c.writers.Get().(*enc.BrotliWriter)
+	encoder.Reset(messageWriter{w})
+	return &writer{encoder: encoder, pool: &c.writers}, nil
+}
+
+func (c *compressor) Decompress(r io.Reader) (io.Reader, error) {
+	decoder, ok := c.readers.Get().(*dec.BrotliReader)
+	if ok {
+		decoder.Reset(r)
+	} else {
+		decoder = dec.NewBrotliReader(r)
+	}
+	return &reader{decoder: decoder, pool: &c.readers}, nil
+}
+
+// Compresses one message, returning the encoder to the pool when closed
+type writer struct {
+	encoder *enc.BrotliWriter
+	pool    *sync.Pool
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.encoder == nil {
+		return 0, enc.ErrWriterClosed
+	}
+	return w.encoder.Write(p)
+}
+
+// Hides the Close method of the writer provided by gRPC, so that closing an
+// encoder only releases its native state
+type messageWriter struct {
+	io.Writer
+}
+
+// Close writes the end of the message and returns the encoder to the pool.
+// If that fails, the encoder's native state is released instead.
+func (w *writer) Close() error {
+	if w.encoder == nil {
+		return nil
+	}
+
+	err := w.encoder.Finish()
+	if err == nil {
+		w.pool.Put(w.encoder)
+	} else {
+		w.encoder.Close()
+	}
+	w.encoder = nil
+	return err
+}
+
+// Decompresses one message, returning the decoder to the pool at the end of
+// the message or when closed
+type reader struct {
+	decoder *dec.BrotliReader
+	pool    *sync.Pool
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if r.decoder == nil {
+		return 0, io.EOF
+	}
+
+	n, err := r.decoder.Read(p)
+	if err == io.EOF {
+		r.Close()
+	}
+	return n, err
+}
+
+// Close returns the decoder to the pool, if the end of the message has not
+// been reached yet.
+func (r *reader) Close() error {
+	if r.decoder != nil {
+		r.pool.Put(r.decoder)
+		r.decoder = nil
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+//go:build go1.25
+// +build go1.25
+
+package brotligrpc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+var errMismatch = errors.New("response does not match request")
+
+var testPayload = []byte(strings.Repeat("The quick brown fox jumps over the lazy dog. ", 20000))
+
+type echoServer struct {
+	testpb.UnimplementedTestServiceServer
+}
+
+func (echoServer) UnaryCall(ctx context.Context, req *testpb.SimpleRequest) (*testpb.SimpleResponse, error) {
+	return &testpb.SimpleResponse{Payload: req.Payload}, nil
+}
+
+// Records the size of the messages received by the server
+type payloadStats struct {
+	mutex       sync.Mutex
+	length      int
+	wireLength  int
+	compression string
+}
+
+func (s *payloadStats) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (s *payloadStats) HandleRPC(ctx context.Context, rs stats.RPCStats) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	switch rs := rs.(type) {
+	case *stats.InHeader:
+		s.compression = rs.Compression
+	case *stats.InPayload:
+		s.length += rs.Length
+		s.wireLength += rs.WireLength
+	}
+}
+
+func (s *payloadStats) TagConn(ctx context.Context, info *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (s *payloadStats) HandleConn(ctx context.Context, cs stats.ConnStats) {}
+
+// Starts an in-process server, returning a client connected to it
+func newTestClient(T *testing.T, serverStats *payloadStats) (testpb.TestServiceClient, func()) {
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer(grpc.StatsHandler(serverStats))
+	testpb.RegisterTestServiceServer(server, echoServer{})
+	go server.Serve(listener)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		T.Fatal(err)
+	}
+
+	return testpb.NewTestServiceClient(conn), func() {
+		conn.Close()
+		server.Stop()
+	}
+}
+
+func TestCompressor(T *testing.T) {
+	serverStats := &payloadStats{}
+	client, stop := newTestClient(T, serverStats)
+	defer stop()
+
+	req := &testpb.SimpleRequest{Payload: &testpb.Payload{Body: testPayload}}
+	resp, err := client.UnaryCall(context.Background(), req, grpc.UseCompressor(Name))
+	if err != nil {
+		T.Fatal(err)
+	}
+	if !bytes.Equal(resp.GetPayload().GetBody(), testPayload) {
+		T.Error("Response does not match request")
+	}
+
+	serverStats.mutex.Lock()
+	defer serverStats.mutex.Unlock()
+	if serverStats.compression != Name {
+		T.Errorf("Expected %q compression, got %q", Name, serverStats.compression)
+	}
+	if serverStats.wireLength*10 > serverStats.length {
+		T.Errorf("Expected compressed request, sent %d bytes for %d", serverStats.wireLength, serverStats.length)
+	}
+}
+
+func TestCompressorConcurrent(T *testing.T) {
+	client, stop := newTestClient(T, &payloadStats{})
+	defer stop()
+
+	// Calls reuse the pooled encoders and decoders
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			payload := append([]byte(strings.Repeat("x", i)), testPayload...)
+			req := &testpb.SimpleRequest{Payload: &testpb.Payload{Body: payload}}
+			resp, err := client.UnaryCall(context.Background(), req, grpc.UseCompressor(Name))
+			if err == nil && !bytes.Equal(resp.GetPayload().GetBody(), payload) {
+				err = errMismatch
+			}
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			T.Error(err)
+		}
+	}
+}
+
+// Fails every write, and records whether it was closed
+type failingWriter struct {
+	closed bool
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	return 0, errMismatch
+}
+
+func (w *failingWriter) Close() error {
+	w.closed = true
+	return nil
+}
+
+func TestCompressorWriteError(T *testing.T) {
+	c, _ := NewCompressor(DefaultQuality)
+	output := &failingWriter{}
+	w, err := c.Compress(output)
+	if err != nil {
+		T.Fatal(err)
+	}
+	w.Write(testPayload)
+	if err := w.Close(); err == nil {
+		T.Error("Expected error from the output")
+	}
+	if output.closed {
+		T.Error("Expected the output provided by gRPC not to be closed")
+	}
+}
+
+func TestNewCompressor(T *testing.T) {
+	if _, err := NewCompressor(12); err == nil {
+		T.Error("Expected error for invalid quality")
+	}
+	c, err := NewCompressor(11)
+	if err != nil {
+		T.Fatal(err)
+	}
+	encoding.RegisterCompressor(c)
+	defer func() {
+		c, _ := NewCompressor(DefaultQuality)
+		encoding.RegisterCompressor(c)
+	}()
+
+	client, stop := newTestClient(T, &payloadStats{})
+	defer stop()
+	req := &testpb.SimpleRequest{Payload: &testpb.Payload{Body: testPayload[:10000]}}
+	resp, err := client.UnaryCall(context.Background(), req, grpc.UseCompressor(Name))
+	if err != nil {
+		T.Fatal(err)
+	}
+	if !bytes.Equal(resp.GetPayload().GetBody(), testPayload[:10000]) {
+		T.Error("Response does not match request")
+	}
+}
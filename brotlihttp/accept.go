@@ -0,0 +1,81 @@
+package brotlihttp
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Encoding is the Content-Encoding token for Brotli
+const Encoding = "br"
+
+// AcceptsBrotli reports whether the Accept-Encoding header of a request allows
+// a Brotli-encoded response. Quality values are taken into account, so
+// "br;q=0" refuses Brotli, and "*" accepts it unless br is listed separately.
+func AcceptsBrotli(r *http.Request) bool {
+	return acceptsEncoding(r.Header, Encoding)
+}
+
+// Reports whether the Accept-Encoding header allows the given encoding with a
+// non-zero quality value
+func acceptsEncoding(header http.Header, encoding string) bool {
+	quality, wildcard := -1.0, -1.0
+	for _, value := range header[http.CanonicalHeaderKey("Accept-Encoding")] {
+		for _, item := range strings.Split(value, ",") {
+			coding, q := parseCoding(item)
+			switch {
+			case strings.EqualFold(coding, encoding):
+				quality = q
+			case coding == "*":
+				wildcard = q
+			}
+		}
+	}
+
+	if quality < 0 {
+		quality = wildcard
+	}
+	return quality > 0
+}
+
+// Splits a content coding from the Accept-Encoding header into its name and
+// quality value. The quality is 1 if it is missing, and 0 if it is invalid.
+func parseCoding(item string) (coding string, q float64) {
+	parts := strings.Split(item, ";")
+	coding = strings.TrimSpace(parts[0])
+	q = 1
+	for _, param := range parts[1:] {
+		param = strings.TrimSpace(param)
+		if len(param) < 2 || !strings.EqualFold(param[:2], "q=") {
+			continue
+		}
+
+		value, err := strconv.ParseFloat(strings.TrimSpace(param[2:]), 64)
+		if err != nil || value < 0 || value > 1 {
+			value = 0
+		}
+		q = value
+	}
+	return coding, q
+}
+
+// Adds a token to the Vary header, unless it is already listed
+func addVary(header http.Header, token string) {
+	for _, value := range header["Vary"] {
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSpace(item)
+			if item == "*" || strings.EqualFold(item, token) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", token)
+}
+
+// Distinguishes the ETag of a representation with the given content encoding
+func encodedETag(etag, encoding string) string {
+	if strings.HasSuffix(etag, "\"") {
+		return etag[:len(etag)-1] + "-" + encoding + "\""
+	}
+	return etag + "-" + encoding
+}
@@ -0,0 +1,206 @@
+package brotlihttp
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"runtime"
+	"sync"
+
+	"gopkg.in/kothar/brotli-go.v0/enc"
+)
+
+// Cache is an in-memory LRU cache of compressed data, so that identical
+// response bodies only need to be compressed once. Entries are keyed by a
+// SHA-256 hash of the uncompressed data together with the compression
+// parameters, and the least recently used entries are evicted when the total
+// size of the compressed data exceeds the limit.
+//
+// A Cache is safe for concurrent use. The slices it returns are shared, and
+// must not be modified.
+type Cache struct {
+	maxBytes int64
+
+	mutex   sync.Mutex
+	size    int64
+	entries map[cacheKey]*list.Element
+	lru     *list.List        // the most recently used entry is at the front
+	pending map[cacheKey]bool // background compressions in progress
+
+	workers chan struct{} // holds a token for each background compression
+}
+
+// Identifies compressed data by its contents and parameters
+type cacheKey struct {
+	hash    [sha256.Size]byte
+	mode    enc.Mode
+	quality int
+	lgwin   int
+	lgblock int
+}
+
+type cacheEntry struct {
+	key  cacheKey
+	data []byte
+}
+
+// NewCache returns a Cache which holds up to maxBytes of compressed data.
+func NewCache(maxBytes int64) *Cache {
+	return &Cache{
+		maxBytes: maxBytes,
+		entries:  make(map[cacheKey]*list.Element),
+		lru:      list.New(),
+		pending:  make(map[cacheKey]bool),
+		workers:  make(chan struct{}, runtime.NumCPU()),
+	}
+}
+
+func newCacheKey(data []byte, params *enc.BrotliParams) cacheKey {
+	return cacheKey{
+		hash:    sha256.Sum256(data),
+		mode:    params.Mode(),
+		quality: params.Quality(),
+		lgwin:   params.Lgwin(),
+		lgblock: params.Lgblock(),
+	}
+}
+
+// Get returns the cached compression of data with the given parameters, if
+// there is one. Default parameters are used if params is nil.
+func (c *Cache) Get(data []byte, params *enc.BrotliParams) ([]byte, bool) {
+	if params == nil {
+		params = enc.NewBrotliParams()
+	}
+	return c.get(newCacheKey(data, params))
+}
+
+// Compress returns the compression of data with the given parameters from the
+// cache, or compresses it with enc.CompressBuffer and adds it to the cache.
+// Default parameters are used if params is nil.
+func (c *Cache) Compress(data []byte, params *enc.BrotliParams) ([]byte, error) {
+	if params == nil {
+		params = enc.NewBrotliParams()
+	}
+
+	key := newCacheKey(data, params)
+	if compressed, ok := c.get(key); ok {
+		return compressed, nil
+	}
+
+	compressed, err := enc.CompressBuffer(params, data, nil)
+	if err != nil {
+		return nil, err
+	}
+	c.add(key, compressed)
+	return compressed, nil
+}
+
+// CompressFast returns the compression of data with params from the cache if
+// there is one. Otherwise it returns the compression of data with fastParams,
+// and compresses data with params in the background to fill the cache for
+// next time. This allows slow, high quality compression to be used without
+// delaying responses.
+//
+// There is at most one background compression per CPU. When they are all
+// busy, or data is larger than the whole cache, data is not compressed in the
+// background, and is tried again the next time it is requested.
+//
+// If fastParams is nil, CompressFast is equivalent to Compress.
+func (c *Cache) CompressFast(data []byte, params, fastParams *enc.BrotliParams) ([]byte, error) {
+	if fastParams == nil {
+		return c.Compress(data, params)
+	}
+	if params == nil {
+		params = enc.NewBrotliParams()
+	}
+
+	key := newCacheKey(data, params)
+	if compressed, ok := c.get(key); ok {
+		return compressed, nil
+	}
+
+	if c.startBackground(key, len(data)) {
+		// The caller may reuse its buffer and parameters
+		input := append([]byte(nil), data...)
+		backgroundParams := *params
+		go func() {
+			compressed, err := enc.CompressBuffer(&backgroundParams, input, nil)
+
+			c.mutex.Lock()
+			delete(c.pending, key)
+			c.mutex.Unlock()
+			<-c.workers
+			if err == nil {
+				c.add(key, compressed)
+			}
+		}()
+	}
+
+	return enc.CompressBuffer(fastParams, data, nil)
+}
+
+// Reserves a worker for a background compression, unless one is in progress
+// for the same key, none are free, or the data is too large to be worth it
+func (c *Cache) startBackground(key cacheKey, size int) bool {
+	if int64(size) > c.maxBytes {
+		return false
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.pending[key] {
+		return false
+	}
+	select {
+	case c.workers <- struct{}{}:
+		c.pending[key] = true
+		return true
+	default:
+		return false
+	}
+}
+
+// Size returns the total size of the compressed data in the cache.
+func (c *Cache) Size() int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.size
+}
+
+// Looks up an entry, marking it as recently used
+func (c *Cache) get(key cacheKey) ([]byte, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(element)
+	return element.Value.(*cacheEntry).data, true
+}
+
+// Adds an entry, evicting the least recently used entries to make room
+func (c *Cache) add(key cacheKey, data []byte) {
+	size := int64(len(data))
+	if size > c.maxBytes {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(element)
+		return
+	}
+
+	for c.size+size > c.maxBytes {
+		oldest := c.lru.Back()
+		entry := c.lru.Remove(oldest).(*cacheEntry)
+		delete(c.entries, entry.key)
+		c.size -= int64(len(entry.data))
+	}
+
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, data: data})
+	c.size += size
+}
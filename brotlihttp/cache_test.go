@@ -0,0 +1,180 @@
+package brotlihttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"gopkg.in/kothar/brotli-go.v0/dec"
+	"gopkg.in/kothar/brotli-go.v0/enc"
+)
+
+func TestCache(T *testing.T) {
+	data := []byte(testBody)
+	params := enc.NewBrotliParams()
+	params.SetQuality(5)
+
+	compressed, err := enc.CompressBuffer(params, data, nil)
+	if err != nil {
+		T.Fatal(err)
+	}
+	// Room for two entries of about the same size
+	limit := int64(len(compressed)) * 5 / 2
+	cache := NewCache(limit)
+
+	if _, ok := cache.Get(data, params); ok {
+		T.Fatal("Expected empty cache")
+	}
+	first, err := cache.Compress(data, params)
+	if err != nil {
+		T.Fatal(err)
+	}
+	second, _ := cache.Compress(data, params)
+	if &first[0] != &second[0] || cache.Size() != int64(len(compressed)) {
+		T.Error("Expected compressed data to be cached")
+	}
+
+	// Different parameters are cached separately
+	params.SetQuality(6)
+	if _, ok := cache.Get(data, params); ok {
+		T.Error("Expected data compressed with other parameters not to be cached")
+	}
+
+	// The least recently used entry is evicted
+	params.SetQuality(5)
+	cache.Compress([]byte(testBody+"2"), params)
+	cache.Get(data, params)
+	cache.Compress([]byte(testBody+"3"), params)
+	if _, ok := cache.Get(data, params); !ok {
+		T.Error("Expected recently used entry to be kept")
+	}
+	if _, ok := cache.Get([]byte(testBody+"2"), params); ok {
+		T.Error("Expected least recently used entry to be evicted")
+	}
+	if cache.Size() > limit {
+		T.Errorf("Cache size %d exceeds the limit", cache.Size())
+	}
+}
+
+// Waits for a background compression to fill the cache
+func waitForCache(T *testing.T, cache *Cache, data []byte, params *enc.BrotliParams) []byte {
+	for i := 0; i < 500; i++ {
+		if compressed, ok := cache.Get(data, params); ok {
+			return compressed
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	T.Fatal("Timed out waiting for background compression")
+	return nil
+}
+
+func TestCacheCompressFast(T *testing.T) {
+	data := []byte(testBody)
+	fastParams := enc.NewBrotliParams()
+	fastParams.SetQuality(1)
+	cache := NewCache(1 << 20)
+
+	fast, err := cache.CompressFast(data, nil, fastParams)
+	if err != nil {
+		T.Fatal(err)
+	}
+	if expected, _ := enc.CompressBuffer(fastParams, data, nil); string(fast) != string(expected) {
+		T.Error("Expected fast compression while the cache is empty")
+	}
+
+	best := waitForCache(T, cache, data, nil)
+	if compressed, _ := cache.CompressFast(data, nil, fastParams); &compressed[0] != &best[0] {
+		T.Error("Expected cached compression once available")
+	}
+	decoded, err := dec.DecompressBuffer(best, nil)
+	if err != nil || string(decoded) != testBody {
+		T.Errorf("Cached data does not decode to original: %v", err)
+	}
+}
+
+func TestCacheCompressFastLimits(T *testing.T) {
+	data := []byte(testBody)
+	fastParams := enc.NewBrotliParams()
+	fastParams.SetQuality(1)
+
+	// Data larger than the cache is not compressed in the background
+	cache := NewCache(int64(len(data)) - 1)
+	if _, err := cache.CompressFast(data, nil, fastParams); err != nil {
+		T.Fatal(err)
+	}
+	if len(cache.pending) != 0 || len(cache.workers) != 0 {
+		T.Error("Expected no background compression for data larger than the cache")
+	}
+
+	// Nor is anything while all the workers are busy
+	cache = NewCache(1 << 20)
+	for i := 0; i < cap(cache.workers); i++ {
+		cache.workers <- struct{}{}
+	}
+	if _, err := cache.CompressFast(data, nil, fastParams); err != nil {
+		T.Fatal(err)
+	}
+	if len(cache.pending) != 0 {
+		T.Error("Expected no background compression while the workers are busy")
+	}
+
+	// Until one is free again
+	<-cache.workers
+	cache.CompressFast(data, nil, fastParams)
+	waitForCache(T, cache, data, nil)
+}
+
+func TestHandlerCache(T *testing.T) {
+	handler := NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testBody))
+	}), nil)
+	handler.Cache = NewCache(1 << 20)
+	handler.FastParams = enc.NewBrotliParams()
+	handler.FastParams.SetQuality(1)
+
+	w := serve(handler, "br")
+	if w.Header().Get("Content-Encoding") != "br" || w.Header().Get("Content-Length") != strconv.Itoa(w.Body.Len()) {
+		T.Fatalf("Expected compressed response with Content-Length, got %v", w.Header())
+	}
+	decoded, err := dec.DecompressBuffer(w.Body.Bytes(), nil)
+	if err != nil || string(decoded) != testBody {
+		T.Errorf("Decoded response does not match original: %v", err)
+	}
+
+	cached := waitForCache(T, handler.Cache, []byte(testBody), handler.Params)
+	w = serve(handler, "br")
+	if w.Body.String() != string(cached) {
+		T.Error("Expected response from the cache")
+	}
+}
+
+func TestHandlerCacheMaxSize(T *testing.T) {
+	var streamed bool
+	var recorder *httptest.ResponseRecorder
+	handler := NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testBody))
+		w.Write([]byte(testBody))
+		// The header is only sent before the handler returns when streaming
+		streamed = recorder.Header().Get("Content-Encoding") == "br"
+	}), nil)
+	handler.Cache = NewCache(1 << 20)
+	handler.MaxCacheSize = len(testBody)
+
+	recorder = httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "br")
+	handler.ServeHTTP(recorder, req)
+
+	if !streamed || recorder.Header().Get("Content-Length") != "" {
+		T.Error("Expected a response larger than MaxCacheSize to be streamed")
+	}
+	if handler.Cache.Size() != 0 {
+		T.Error("Expected a response larger than MaxCacheSize not to be cached")
+	}
+	decoded, err := dec.DecompressBuffer(recorder.Body.Bytes(), nil)
+	if err != nil || string(decoded) != testBody+testBody {
+		T.Errorf("Decoded response does not match original: %v", err)
+	}
+}
@@ -0,0 +1,285 @@
+package brotlihttp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"gopkg.in/kothar/brotli-go.v0/dec"
+	"gopkg.in/kothar/brotli-go.v0/enc"
+)
+
+// DictionaryEncoding is the Content-Encoding token for Brotli compression with
+// a shared dictionary, as defined by Compression Dictionary Transport
+const DictionaryEncoding = "dcb"
+
+// DictionaryMagic is the magic number at the start of a dcb stream
+var DictionaryMagic = []byte{0xff, 0x44, 0x43, 0x42}
+
+// DictionaryHeaderSize is the size of the dcb stream header, which consists of
+// DictionaryMagic followed by the SHA-256 hash of the dictionary
+const DictionaryHeaderSize = 4 + sha256.Size
+
+var (
+	// ErrNotDictionaryCompressed is returned when a stream does not start
+	// with the dcb magic number
+	ErrNotDictionaryCompressed = errors.New("brotlihttp: missing dcb magic number")
+
+	// ErrUnknownDictionary is returned when a dcb stream refers to a
+	// dictionary which has not been registered
+	ErrUnknownDictionary = errors.New("brotlihttp: unknown dictionary")
+)
+
+// Dictionary is a shared dictionary which clients can use to decode responses
+// with the dcb content encoding.
+type Dictionary struct {
+	// Data is the contents of the dictionary, which must be identical to
+	// the resource the client stored it from
+	Data []byte
+
+	// Hash is the SHA-256 hash of Data
+	Hash [sha256.Size]byte
+
+	// Match is the URL pattern of the requests the client may use the
+	// dictionary for, such as "/js/app.*.js". It is matched against the path
+	// of the request, where "*" matches any sequence of characters and other
+	// characters match themselves.
+	Match string
+
+	// MatchDest optionally restricts the request destinations the client
+	// may use the dictionary for, such as "script"
+	MatchDest []string
+
+	// ID is an optional identifier which the client sends back in the
+	// Dictionary-ID header
+	ID string
+}
+
+// NewDictionary returns a Dictionary with the given contents, for use with
+// requests which match the URL pattern.
+func NewDictionary(data []byte, match string) *Dictionary {
+	return &Dictionary{
+		Data:  data,
+		Hash:  sha256.Sum256(data),
+		Match: match,
+	}
+}
+
+// Advertise sets the Use-As-Dictionary header on the response which serves
+// the dictionary itself, so that the client stores it for later requests. An
+// error is returned, and the header is not set, if Match, MatchDest or ID
+// contain characters which can't be sent in the header.
+func (d *Dictionary) Advertise(header http.Header) error {
+	match, err := quoteString(d.Match)
+	if err != nil {
+		return err
+	}
+	value := "match=" + match
+	if len(d.MatchDest) > 0 {
+		dests := make([]string, len(d.MatchDest))
+		for i, dest := range d.MatchDest {
+			if dests[i], err = quoteString(dest); err != nil {
+				return err
+			}
+		}
+		value += ", match-dest=(" + strings.Join(dests, " ") + ")"
+	}
+	if d.ID != "" {
+		id, err := quoteString(d.ID)
+		if err != nil {
+			return err
+		}
+		value += ", id=" + id
+	}
+	header.Set("Use-As-Dictionary", value)
+	return nil
+}
+
+// Formats a structured field string, which can only contain printable ASCII
+// characters, escaping quotes and backslashes
+func quoteString(s string) (string, error) {
+	quoted := make([]byte, 0, len(s)+2)
+	quoted = append(quoted, '"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x20 || c > 0x7e {
+			return "", fmt.Errorf("brotlihttp: invalid character %q in dictionary header value %q", c, s)
+		}
+		if c == '"' || c == '\\' {
+			quoted = append(quoted, '\\')
+		}
+		quoted = append(quoted, c)
+	}
+	return string(append(quoted, '"')), nil
+}
+
+// Reports whether the dictionary may be used for a request with the given path
+func (d *Dictionary) matches(path string) bool {
+	return matchPattern(d.Match, path)
+}
+
+// Matches a string against a pattern in which "*" matches any sequence of
+// characters, remembering the last "*" to backtrack to
+func matchPattern(pattern, s string) bool {
+	p, i := 0, 0
+	star, starI := -1, 0
+	for i < len(s) {
+		switch {
+		case p < len(pattern) && pattern[p] == '*':
+			star, starI = p, i
+			p++
+		case p < len(pattern) && pattern[p] == s[i]:
+			p++
+			i++
+		case star >= 0:
+			starI++
+			p, i = star+1, starI
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// Dictionaries is a set of shared dictionaries, registered by their SHA-256
+// hash. It is safe for concurrent use.
+type Dictionaries struct {
+	mutex        sync.RWMutex
+	dictionaries map[[sha256.Size]byte]*Dictionary
+}
+
+// NewDictionaries returns an empty set of dictionaries.
+func NewDictionaries() *Dictionaries {
+	return &Dictionaries{dictionaries: make(map[[sha256.Size]byte]*Dictionary)}
+}
+
+// Add registers a dictionary.
+func (s *Dictionaries) Add(d *Dictionary) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.dictionaries[d.Hash] = d
+}
+
+// Remove unregisters the dictionary with the given hash.
+func (s *Dictionaries) Remove(hash [sha256.Size]byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.dictionaries, hash)
+}
+
+// Get returns the dictionary with the given hash, or nil if there isn't one.
+func (s *Dictionaries) Get(hash [sha256.Size]byte) *Dictionary {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.dictionaries[hash]
+}
+
+// Negotiate returns the dictionary to compress the response to a request
+// with, or nil if the request does not accept the dcb encoding or does not
+// have an Available-Dictionary header naming a registered dictionary whose
+// Match pattern matches the request path.
+func (s *Dictionaries) Negotiate(r *http.Request) *Dictionary {
+	if !acceptsEncoding(r.Header, DictionaryEncoding) {
+		return nil
+	}
+
+	// The header is a structured field byte sequence, :base64:
+	value := strings.TrimSpace(r.Header.Get("Available-Dictionary"))
+	if len(value) < 2 || value[0] != ':' || value[len(value)-1] != ':' {
+		return nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(value[1 : len(value)-1])
+	if err != nil || len(decoded) != sha256.Size {
+		return nil
+	}
+
+	var hash [sha256.Size]byte
+	copy(hash[:], decoded)
+	if d := s.Get(hash); d != nil && d.matches(r.URL.Path) {
+		return d
+	}
+	return nil
+}
+
+// WriteDictionaryHeader writes the header of a dcb stream compressed with the
+// dictionary with the given hash.
+func WriteDictionaryHeader(w io.Writer, hash [sha256.Size]byte) error {
+	header := make([]byte, 0, DictionaryHeaderSize)
+	header = append(header, DictionaryMagic...)
+	header = append(header, hash[:]...)
+	_, err := w.Write(header)
+	return err
+}
+
+// ReadDictionaryHeader reads the header of a dcb stream, returning the hash of
+// the dictionary it was compressed with.
+func ReadDictionaryHeader(r io.Reader) ([sha256.Size]byte, error) {
+	var hash [sha256.Size]byte
+	header := make([]byte, DictionaryHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return hash, err
+	}
+	if !bytes.Equal(header[:len(DictionaryMagic)], DictionaryMagic) {
+		return hash, ErrNotDictionaryCompressed
+	}
+
+	copy(hash[:], header[len(DictionaryMagic):])
+	return hash, nil
+}
+
+// CompressDictionary compresses data with the dictionary, returning the
+// result in the dcb format.
+func CompressDictionary(params *enc.BrotliParams, d *Dictionary, data []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+	WriteDictionaryHeader(&buffer, d.Hash)
+
+	compressed, err := enc.CompressBufferDict(params, data, d.Data, nil)
+	if err != nil {
+		return nil, err
+	}
+	buffer.Write(compressed)
+	return buffer.Bytes(), nil
+}
+
+// DecompressDictionary decompresses data in the dcb format, using the
+// dictionary named in its header.
+func DecompressDictionary(dictionaries *Dictionaries, data []byte) ([]byte, error) {
+	hash, err := ReadDictionaryHeader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	d := dictionaries.Get(hash)
+	if d == nil {
+		return nil, ErrUnknownDictionary
+	}
+
+	return dec.DecompressBufferDict(data[DictionaryHeaderSize:], d.Data, nil)
+}
+
+// NewDictionaryReader reads the header of a dcb stream and returns a
+// BrotliReader which decompresses the rest of it, using the dictionary named
+// in the header.
+func NewDictionaryReader(r io.Reader, dictionaries *Dictionaries) (*dec.BrotliReader, error) {
+	hash, err := ReadDictionaryHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	d := dictionaries.Get(hash)
+	if d == nil {
+		return nil, ErrUnknownDictionary
+	}
+
+	return dec.NewBrotliReaderDict(r, d.Data), nil
+}
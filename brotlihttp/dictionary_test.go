@@ -0,0 +1,180 @@
+package brotlihttp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// A previous version of the response, which makes a good dictionary for the
+// current one
+var testDictionary = bytes.Replace([]byte(testBody), []byte("lazy"), []byte("sleepy"), -1)
+
+func availableDictionary(d *Dictionary) string {
+	return ":" + base64.StdEncoding.EncodeToString(d.Hash[:]) + ":"
+}
+
+func TestDictionaryAdvertise(T *testing.T) {
+	d := NewDictionary(testDictionary, "/js/app.*.js")
+	header := http.Header{}
+	d.Advertise(header)
+	if value := header.Get("Use-As-Dictionary"); value != `match="/js/app.*.js"` {
+		T.Errorf("Unexpected Use-As-Dictionary %q", value)
+	}
+
+	d.MatchDest = []string{"script"}
+	d.ID = `app "v1"\`
+	if err := d.Advertise(header); err != nil {
+		T.Fatal(err)
+	}
+	if value := header.Get("Use-As-Dictionary"); value != `match="/js/app.*.js", match-dest=("script"), id="app \"v1\"\\"` {
+		T.Errorf("Unexpected Use-As-Dictionary %q", value)
+	}
+
+	// Only printable ASCII can be sent
+	for _, id := range []string{"caf\u00e9", "a\x00b", "tab\t"} {
+		d.ID = id
+		header = http.Header{}
+		if err := d.Advertise(header); err == nil || header.Get("Use-As-Dictionary") != "" {
+			T.Errorf("Expected error for ID %q", id)
+		}
+	}
+}
+
+func TestMatchPattern(T *testing.T) {
+	tests := []struct {
+		pattern, path string
+		expected      bool
+	}{
+		{"/js/app.*.js", "/js/app.v1.js", true},
+		{"/js/app.*.js", "/js/app..js", true},
+		{"/js/app.*.js", "/js/app.v1.css", false},
+		{"/js/app.*.js", "/css/app.v1.js", false},
+		{"/*", "/", true},
+		{"/*", "/any/path", true},
+		{"/a*b*c", "/aXbYbZc", true},
+		{"/a*b*c", "/aXbYbZ", false},
+		{"/exact", "/exact", true},
+		{"/exact", "/exact/more", false},
+		{"", "/", false},
+	}
+	for _, test := range tests {
+		if matched := matchPattern(test.pattern, test.path); matched != test.expected {
+			T.Errorf("matchPattern(%q, %q) = %v, expected %v", test.pattern, test.path, matched, test.expected)
+		}
+	}
+}
+
+func TestDictionaryNegotiate(T *testing.T) {
+	d := NewDictionary(testDictionary, "/js/*")
+	other := NewDictionary([]byte("other"), "/*")
+	dictionaries := NewDictionaries()
+	dictionaries.Add(d)
+
+	tests := []struct {
+		path           string
+		acceptEncoding string
+		available      string
+		expected       *Dictionary
+	}{
+		{"/js/app.js", "br, dcb", availableDictionary(d), d},
+		{"/css/app.css", "br, dcb", availableDictionary(d), nil},
+		{"/js/app.js", "br", availableDictionary(d), nil},
+		{"/js/app.js", "br, dcb", "", nil},
+		{"/js/app.js", "br, dcb", availableDictionary(other), nil},
+		{"/js/app.js", "br, dcb", "invalid", nil},
+		{"/js/app.js", "br, dcb", ":aW52YWxpZA==:", nil},
+	}
+
+	for _, test := range tests {
+		r, _ := http.NewRequest("GET", test.path, nil)
+		r.Header.Set("Accept-Encoding", test.acceptEncoding)
+		r.Header.Set("Available-Dictionary", test.available)
+		if negotiated := dictionaries.Negotiate(r); negotiated != test.expected {
+			T.Errorf("Negotiate(%q, %q, %q) = %v, expected %v", test.path, test.acceptEncoding, test.available, negotiated, test.expected)
+		}
+	}
+
+	dictionaries.Remove(d.Hash)
+	if dictionaries.Get(d.Hash) != nil {
+		T.Error("Expected dictionary to be removed")
+	}
+}
+
+func TestDictionaryCompress(T *testing.T) {
+	d := NewDictionary(testDictionary, "/*")
+	dictionaries := NewDictionaries()
+	dictionaries.Add(d)
+
+	compressed, err := CompressDictionary(nil, d, []byte(testBody))
+	if err != nil {
+		T.Fatal(err)
+	}
+	if !bytes.HasPrefix(compressed, append(append([]byte(nil), DictionaryMagic...), d.Hash[:]...)) {
+		T.Fatal("Expected magic number and dictionary hash")
+	}
+	if withoutDictionary := compress(T, []byte(testBody)); len(compressed)-DictionaryHeaderSize >= len(withoutDictionary) {
+		T.Errorf("Expected the dictionary to help, got %d bytes compared to %d", len(compressed)-DictionaryHeaderSize, len(withoutDictionary))
+	}
+
+	decoded, err := DecompressDictionary(dictionaries, compressed)
+	if err != nil || string(decoded) != testBody {
+		T.Fatalf("Decoded data does not match original: %v", err)
+	}
+
+	reader, err := NewDictionaryReader(bytes.NewReader(compressed), dictionaries)
+	if err != nil {
+		T.Fatal(err)
+	}
+	decoded, err = ioutil.ReadAll(reader)
+	reader.Close()
+	if err != nil || string(decoded) != testBody {
+		T.Errorf("Streamed data does not match original: %v", err)
+	}
+
+	// Errors
+	if _, err := DecompressDictionary(NewDictionaries(), compressed); err != ErrUnknownDictionary {
+		T.Errorf("Expected ErrUnknownDictionary, got %v", err)
+	}
+	if _, err := DecompressDictionary(dictionaries, []byte(testBody)); err != ErrNotDictionaryCompressed {
+		T.Errorf("Expected ErrNotDictionaryCompressed, got %v", err)
+	}
+	if _, err := DecompressDictionary(dictionaries, compressed[:10]); err == nil {
+		T.Error("Expected error for truncated header")
+	}
+}
+
+func TestHandlerDictionary(T *testing.T) {
+	d := NewDictionary(testDictionary, "/*")
+	handler := NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testBody))
+	}), nil)
+	handler.Dictionaries = NewDictionaries()
+	handler.Dictionaries.Add(d)
+
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip, br, dcb")
+	r.Header.Set("Available-Dictionary", availableDictionary(d))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if w.Header().Get("Content-Encoding") != "dcb" {
+		T.Fatalf("Expected dcb Content-Encoding, got %q", w.Header().Get("Content-Encoding"))
+	}
+	if vary := w.Header()["Vary"]; len(vary) != 2 || vary[0] != "Accept-Encoding" || vary[1] != "Available-Dictionary" {
+		T.Errorf("Unexpected Vary %q", vary)
+	}
+	decoded, err := DecompressDictionary(handler.Dictionaries, w.Body.Bytes())
+	if err != nil || string(decoded) != testBody {
+		T.Errorf("Decoded response does not match original: %v", err)
+	}
+
+	// Without a dictionary
+	w = serve(handler, "br, dcb")
+	if w.Header().Get("Content-Encoding") != "br" {
+		T.Errorf("Expected br Content-Encoding, got %q", w.Header().Get("Content-Encoding"))
+	}
+}
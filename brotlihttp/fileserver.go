@@ -0,0 +1,193 @@
+//go:build go1.7
+// +build go1.7
+
+package brotlihttp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"gopkg.in/kothar/brotli-go.v0/dec"
+)
+
+// The file extension of precompressed files
+const fileExtension = ".br"
+
+// The largest size a precompressed file is decompressed to for clients which
+// don't accept br
+const maxDecodedFileSize = 1 << 30
+
+// The size of the buffer for decompressing files
+const decodeBufferSize = 32 * 1024
+
+// FileServer returns a handler like http.FileServer, which serves a
+// precompressed foo.js.br file in place of foo.js if it exists.
+//
+// Clients which accept the br content encoding are sent the precompressed file
+// with Content-Encoding: br, and other clients are sent foo.js if it exists,
+// or the result of decompressing foo.js.br otherwise. The Content-Type is
+// based on the name of the original file. Each representation has its own
+// ETag, and conditional and Range requests are handled by http.ServeContent,
+// so ranges refer to the compressed data for clients which accept br.
+//
+// Decompressed files are streamed as they are decoded, up to 1GB, so only
+// conditional requests are handled for them, not ranges. If decoding fails
+// after the response has started, the connection is aborted.
+//
+// Paths without a precompressed file, including directory listings, are
+// served by http.FileServer.
+func FileServer(root http.FileSystem) http.Handler {
+	return &fileHandler{
+		root:           root,
+		fallback:       http.FileServer(root),
+		maxDecodedSize: maxDecodedFileSize,
+	}
+}
+
+type fileHandler struct {
+	root           http.FileSystem
+	fallback       http.Handler
+	maxDecodedSize int64
+}
+
+func (h *fileHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := path.Clean("/" + r.URL.Path)
+	if strings.HasSuffix(r.URL.Path, "/") {
+		name = path.Join(name, "index.html")
+	} else if strings.HasSuffix(name, "/index.html") {
+		// Let http.FileServer redirect to the directory
+		h.fallback.ServeHTTP(w, r)
+		return
+	}
+
+	compressed, compressedInfo := h.open(name + fileExtension)
+	if compressed == nil {
+		h.fallback.ServeHTTP(w, r)
+		return
+	}
+	defer compressed.Close()
+
+	header := w.Header()
+	addVary(header, "Accept-Encoding")
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", contentType(name, compressed))
+	}
+	etag := header.Get("Etag")
+
+	if AcceptsBrotli(r) {
+		header.Set("Content-Encoding", Encoding)
+		if etag == "" {
+			etag = fileETag(compressedInfo)
+		}
+		header.Set("Etag", encodedETag(etag, Encoding))
+		http.ServeContent(w, r, name, compressedInfo.ModTime(), compressed)
+		return
+	}
+
+	// Serve the original file if there is one
+	if original, originalInfo := h.open(name); original != nil {
+		defer original.Close()
+		if etag == "" {
+			header.Set("Etag", fileETag(originalInfo))
+		}
+		http.ServeContent(w, r, name, originalInfo.ModTime(), original)
+		return
+	}
+
+	// Otherwise decompress the file as it is sent
+	if etag == "" {
+		etag = fileETag(compressedInfo)
+		header.Set("Etag", etag)
+	}
+	modtime := compressedInfo.ModTime()
+	header.Set("Last-Modified", modtime.UTC().Format(http.TimeFormat))
+	if notModified(r, etag, modtime) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	reader := dec.NewBrotliReader(compressed)
+	reader.SetMaxOutput(h.maxDecodedSize)
+	defer reader.Close()
+
+	// Decode the start of the file before sending the header, so that an
+	// error there can still be reported
+	decoded := bufio.NewReaderSize(reader, decodeBufferSize)
+	if _, err := decoded.Peek(1); err != nil && err != io.EOF {
+		http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	header.Set("Accept-Ranges", "none")
+	w.WriteHeader(http.StatusOK)
+	if r.Method == "HEAD" {
+		return
+	}
+	if _, err := io.Copy(w, decoded); err != nil {
+		// Abandon the response, so that the client can't mistake it for a
+		// complete one
+		panic(errAbortResponse)
+	}
+}
+
+// Reports whether a GET or HEAD request can be answered with 304 Not Modified,
+// checking If-None-Match and If-Modified-Since as http.ServeContent does
+func notModified(r *http.Request, etag string, modtime time.Time) bool {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return false
+	}
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	return err == nil && !modtime.Truncate(time.Second).After(since)
+}
+
+// Opens a regular file, or returns nil if there isn't one
+func (h *fileHandler) open(name string) (http.File, os.FileInfo) {
+	file, err := h.root.Open(name)
+	if err != nil {
+		return nil, nil
+	}
+
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		file.Close()
+		return nil, nil
+	}
+	return file, info
+}
+
+// Determines the content type from the name of the original file, or from
+// its start if the extension isn't known
+func contentType(name string, compressed io.ReadSeeker) string {
+	if ctype := mime.TypeByExtension(path.Ext(name)); ctype != "" {
+		return ctype
+	}
+
+	reader := dec.NewBrotliReader(compressed)
+	defer reader.Close()
+	start, _ := ioutil.ReadAll(io.LimitReader(reader, 512))
+	compressed.Seek(0, io.SeekStart)
+
+	return http.DetectContentType(start)
+}
+
+// A strong ETag for a file, based on its size and modification time
+func fileETag(info os.FileInfo) string {
+	return fmt.Sprintf("\"%x-%x\"", info.ModTime().UnixNano(), info.Size())
+}
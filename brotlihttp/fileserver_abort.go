@@ -0,0 +1,9 @@
+//go:build go1.7 && !go1.8
+// +build go1.7,!go1.8
+
+package brotlihttp
+
+import "errors"
+
+// Panicking with this aborts a response, which net/http logs
+var errAbortResponse = errors.New("brotlihttp: response aborted")
@@ -0,0 +1,9 @@
+//go:build go1.8
+// +build go1.8
+
+package brotlihttp
+
+import "net/http"
+
+// Panicking with this aborts a response without net/http logging the panic
+var errAbortResponse = http.ErrAbortHandler
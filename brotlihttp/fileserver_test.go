@@ -0,0 +1,151 @@
+//go:build go1.7
+// +build go1.7
+
+package brotlihttp
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/kothar/brotli-go.v0/dec"
+	"gopkg.in/kothar/brotli-go.v0/enc"
+)
+
+// Creates a directory with foo.js and foo.js.br, bar.txt.br without the
+// original, baz.css without a compressed version, and a corrupt qux.txt.br
+func newTestRoot(T *testing.T) string {
+	dir, err := ioutil.TempDir("", "brotlihttp")
+	if err != nil {
+		T.Fatal(err)
+	}
+
+	compressed, err := enc.CompressBuffer(nil, []byte(testBody), nil)
+	if err != nil {
+		T.Fatal(err)
+	}
+
+	files := map[string][]byte{
+		"foo.js":     []byte(testBody),
+		"foo.js.br":  compressed,
+		"bar.txt.br": compressed,
+		"baz.css":    []byte(testBody),
+		"qux.txt.br": []byte(testBody),
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			T.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func serveFile(handler http.Handler, path string, header http.Header) *httptest.ResponseRecorder {
+	r, _ := http.NewRequest("GET", path, nil)
+	for key, values := range header {
+		r.Header[key] = values
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestFileServer(T *testing.T) {
+	dir := newTestRoot(T)
+	defer os.RemoveAll(dir)
+	handler := FileServer(http.Dir(dir))
+	acceptBrotli := http.Header{"Accept-Encoding": {"gzip, br"}}
+
+	// Precompressed
+	w := serveFile(handler, "/foo.js", acceptBrotli)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "br" {
+		T.Fatalf("Expected precompressed file, got %d %q", w.Code, w.Header().Get("Content-Encoding"))
+	}
+	if !strings.Contains(w.Header().Get("Content-Type"), "javascript") {
+		T.Errorf("Expected JavaScript Content-Type, got %q", w.Header().Get("Content-Type"))
+	}
+	if w.Header().Get("Vary") != "Accept-Encoding" {
+		T.Errorf("Expected Vary: Accept-Encoding, got %q", w.Header().Get("Vary"))
+	}
+	decoded, err := dec.DecompressBuffer(w.Body.Bytes(), nil)
+	if err != nil {
+		T.Fatal(err)
+	}
+	if string(decoded) != testBody {
+		T.Error("Decoded response does not match original")
+	}
+	compressedETag := w.Header().Get("Etag")
+
+	// Original
+	for _, path := range []string{"/foo.js", "/bar.txt", "/baz.css"} {
+		w = serveFile(handler, path, nil)
+		if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "" || w.Body.String() != testBody {
+			T.Errorf("%s: Expected uncompressed file, got %d %q", path, w.Code, w.Header().Get("Content-Encoding"))
+		}
+	}
+	w = serveFile(handler, "/bar.txt", nil)
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		T.Errorf("Expected text Content-Type, got %q", w.Header().Get("Content-Type"))
+	}
+	if etag := w.Header().Get("Etag"); etag == "" || etag == compressedETag {
+		T.Errorf("Expected different ETags for each encoding, got %q and %q", etag, compressedETag)
+	}
+
+	// Conditional requests
+	w = serveFile(handler, "/foo.js", http.Header{
+		"Accept-Encoding": {"br"},
+		"If-None-Match":   {compressedETag},
+	})
+	if w.Code != http.StatusNotModified {
+		T.Errorf("Expected 304 Not Modified, got %d", w.Code)
+	}
+
+	// Ranges of the compressed data
+	w = serveFile(handler, "/foo.js", http.Header{
+		"Accept-Encoding": {"br"},
+		"Range":           {"bytes=0-9"},
+	})
+	compressed, _ := ioutil.ReadFile(filepath.Join(dir, "foo.js.br"))
+	if w.Code != http.StatusPartialContent || w.Body.String() != string(compressed[:10]) {
+		T.Errorf("Expected range of compressed file, got %d", w.Code)
+	}
+
+	// Decompressed data is sent whole
+	w = serveFile(handler, "/bar.txt", http.Header{"Range": {"bytes=4-8"}})
+	if w.Code != http.StatusOK || w.Body.String() != testBody || w.Header().Get("Accept-Ranges") != "none" {
+		T.Errorf("Expected whole decompressed file, got %d %q", w.Code, w.Header().Get("Accept-Ranges"))
+	}
+	w = serveFile(handler, "/bar.txt", http.Header{"If-None-Match": {w.Header().Get("Etag")}})
+	if w.Code != http.StatusNotModified {
+		T.Errorf("Expected 304 Not Modified for decompressed file, got %d", w.Code)
+	}
+	w = serveFile(handler, "/bar.txt", http.Header{"If-Modified-Since": {w.Header().Get("Last-Modified")}})
+	if w.Code != http.StatusNotModified {
+		T.Errorf("Expected 304 Not Modified for decompressed file, got %d", w.Code)
+	}
+}
+
+func TestFileServerDecodeErrors(T *testing.T) {
+	dir := newTestRoot(T)
+	defer os.RemoveAll(dir)
+	handler := FileServer(http.Dir(dir)).(*fileHandler)
+
+	// Errors at the start of the file are reported
+	w := serveFile(handler, "/qux.txt", nil)
+	if w.Code != http.StatusInternalServerError {
+		T.Errorf("Expected 500 Internal Server Error for corrupt file, got %d", w.Code)
+	}
+
+	// Later errors abort the response
+	handler.maxDecodedSize = 100
+	defer func() {
+		if err := recover(); err != errAbortResponse {
+			T.Errorf("Expected response to be aborted, got %v", err)
+		}
+	}()
+	serveFile(handler, "/bar.txt", nil)
+}
@@ -0,0 +1,326 @@
+// Package brotlihttp provides net/http helpers for Brotli content encoding
+package brotlihttp // import "gopkg.in/kothar/brotli-go.v0/brotlihttp"
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gopkg.in/kothar/brotli-go.v0/enc"
+)
+
+// DefaultQuality is the compression quality used by NewHandler if no
+// parameters are given. It compresses well while being fast enough to
+// compress dynamic responses on the fly.
+const DefaultQuality = 5
+
+// DefaultMinSize is the size below which responses are not compressed by
+// default, as the saving would not be worth the overhead.
+const DefaultMinSize = 1024
+
+// DefaultExcludedTypes lists the content types which are not compressed by
+// default, because they are compressed already.
+var DefaultExcludedTypes = []string{
+	"image/gif",
+	"image/jpeg",
+	"image/png",
+	"image/webp",
+	"audio/",
+	"video/",
+	"application/gzip",
+	"application/x-gzip",
+	"application/zip",
+	"application/x-brotli",
+}
+
+// Handler wraps another http.Handler, compressing its responses with Brotli
+// for clients which accept the br content encoding.
+//
+// A response is not compressed if it already has a Content-Encoding, if it is
+// smaller than MinSize, or if its Content-Type is excluded. The decision is
+// made once MinSize bytes of the response have been written, when the
+// response is flushed, or when the wrapped handler returns.
+//
+// If a Cache is set, whole responses up to MaxCacheSize are buffered so that
+// the compressed data can be looked up in the cache, unless the response is
+// flushed. Larger responses are compressed as they are written.
+type Handler struct {
+	// Handler is the wrapped handler
+	Handler http.Handler
+
+	// Params are the compression parameters. Default parameters are used if
+	// nil, which are very slow for dynamic content.
+	Params *enc.BrotliParams
+
+	// MinSize is the size in bytes below which responses are not compressed
+	MinSize int
+
+	// ExcludedTypes lists the media types which are not compressed. Entries
+	// ending in "/", such as "video/", exclude all subtypes.
+	ExcludedTypes []string
+
+	// Cache, if not nil, stores compressed responses so that identical
+	// responses are only compressed once.
+	Cache *Cache
+
+	// MaxCacheSize is the size in bytes of the largest response which is
+	// buffered to be looked up in the Cache. If 0, it is the size of the
+	// Cache.
+	MaxCacheSize int
+
+	// FastParams, if not nil, are used to compress responses which are not
+	// in the Cache yet, while they are compressed with Params in the
+	// background. This allows high quality settings to be used for Params
+	// without slowing down responses.
+	FastParams *enc.BrotliParams
+
+	// Dictionaries, if not nil, are the shared dictionaries which responses
+	// are compressed with for clients which accept the dcb content encoding
+	// and have one of them available. Such responses are not cached.
+	Dictionaries *Dictionaries
+}
+
+// NewHandler returns a Handler which compresses the responses of h using the
+// provided compression parameters, with the default minimum size and excluded
+// content types. DefaultQuality is used if params is nil.
+func NewHandler(h http.Handler, params *enc.BrotliParams) *Handler {
+	if params == nil {
+		params = enc.NewBrotliParams()
+		params.SetQuality(DefaultQuality)
+	}
+
+	return &Handler{
+		Handler:       h,
+		Params:        params,
+		MinSize:       DefaultMinSize,
+		ExcludedTypes: DefaultExcludedTypes,
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	addVary(w.Header(), "Accept-Encoding")
+	var dictionary *Dictionary
+	if h.Dictionaries != nil {
+		addVary(w.Header(), "Available-Dictionary")
+		dictionary = h.Dictionaries.Negotiate(r)
+	}
+	if dictionary == nil && !AcceptsBrotli(r) {
+		h.Handler.ServeHTTP(w, r)
+		return
+	}
+
+	rw := &responseWriter{
+		ResponseWriter: w,
+		handler:        h,
+		dictionary:     dictionary,
+		status:         http.StatusOK,
+	}
+	h.Handler.ServeHTTP(rw, r)
+
+	// Not deferred, so that if the handler panics, the stream isn't finished
+	// as if the response were complete, and net/http aborts it instead. The
+	// encoder's native state is then freed by its finalizer.
+	rw.close()
+}
+
+// The largest response to buffer for the Cache, or 0 without one
+func (h *Handler) maxCacheSize() int {
+	switch {
+	case h.Cache == nil:
+		return 0
+	case h.MaxCacheSize > 0:
+		return h.MaxCacheSize
+	}
+	return int(h.Cache.maxBytes)
+}
+
+// Reports whether the content type is excluded from compression
+func (h *Handler) excluded(contentType string) bool {
+	return excludedType(h.ExcludedTypes, contentType)
+}
+
+// Reports whether the content type matches one of the excluded types
+func excludedType(excludedTypes []string, contentType string) bool {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	for _, excluded := range excludedTypes {
+		if strings.HasSuffix(excluded, "/") {
+			if strings.HasPrefix(mediaType, excluded) {
+				return true
+			}
+		} else if mediaType == excluded {
+			return true
+		}
+	}
+	return false
+}
+
+// Buffers the start of a response until it can decide whether to compress it
+type responseWriter struct {
+	http.ResponseWriter
+	handler    *Handler
+	dictionary *Dictionary // the shared dictionary to compress with, if any
+
+	status      int
+	wroteHeader bool   // whether the wrapped handler has written the header
+	decided     bool   // whether the header has been sent on
+	complete    bool   // whether the whole response is buffered
+	buffer      []byte // the start of the response, until decided
+
+	writer *enc.BrotliWriter // compresses the response, if decided to
+}
+
+func (rw *responseWriter) WriteHeader(status int) {
+	if rw.wroteHeader {
+		return
+	}
+	rw.wroteHeader = true
+	rw.status = status
+
+	// Responses without a body can be passed on straight away
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified {
+		rw.decide(false)
+	}
+}
+
+func (rw *responseWriter) Write(p []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	if !rw.decided {
+		rw.buffer = append(rw.buffer, p...)
+		if len(rw.buffer) < rw.handler.MinSize || len(rw.buffer) <= rw.handler.maxCacheSize() {
+			return len(p), nil
+		}
+		if err := rw.decide(true); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	if rw.writer != nil {
+		return rw.writer.Write(p)
+	}
+	return rw.ResponseWriter.Write(p)
+}
+
+// Flush implements the http.Flusher interface, compressing and sending
+// everything written so far.
+func (rw *responseWriter) Flush() {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	// The size of the response isn't known yet, so assume it is large enough
+	if !rw.decided {
+		rw.decide(true)
+	}
+	if rw.writer != nil {
+		rw.writer.Flush()
+	}
+
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Called when the wrapped handler returns, to finish the response
+func (rw *responseWriter) close() error {
+	if !rw.decided {
+		if !rw.wroteHeader && len(rw.buffer) == 0 {
+			// Leave the response to net/http
+			return nil
+		}
+
+		// The whole response has been buffered, so its size is known
+		if rw.Header().Get("Content-Length") == "" && len(rw.buffer) < rw.handler.MinSize {
+			rw.Header().Set("Content-Length", strconv.Itoa(len(rw.buffer)))
+		}
+		rw.complete = true
+		if err := rw.decide(len(rw.buffer) >= rw.handler.MinSize); err != nil {
+			return err
+		}
+	}
+
+	if rw.writer != nil {
+		return rw.writer.Close()
+	}
+	return nil
+}
+
+// Sends the header and buffered data on, compressing the rest of the response
+// if it is large enough and nothing else rules it out.
+func (rw *responseWriter) decide(largeEnough bool) error {
+	rw.decided = true
+	header := rw.Header()
+
+	if len(rw.buffer) > 0 && header.Get("Content-Type") == "" {
+		// Otherwise net/http would sniff the compressed data
+		header.Set("Content-Type", http.DetectContentType(rw.buffer))
+	}
+
+	if largeEnough && rw.shouldCompress() {
+		if rw.complete && rw.handler.Cache != nil && rw.dictionary == nil {
+			// Send the compressed response from the cache as it is
+			compressed, err := rw.handler.Cache.CompressFast(rw.buffer, rw.handler.Params, rw.handler.FastParams)
+			if err == nil {
+				setContentEncoding(header, Encoding)
+				header.Set("Content-Length", strconv.Itoa(len(compressed)))
+				rw.buffer = compressed
+			}
+		} else if rw.dictionary != nil {
+			setContentEncoding(header, DictionaryEncoding)
+			header.Del("Content-Length")
+			rw.writer = enc.NewBrotliWriterDict(rw.handler.Params, rw.dictionary.Data, rw.ResponseWriter)
+		} else {
+			setContentEncoding(header, Encoding)
+			header.Del("Content-Length")
+			rw.writer = enc.NewBrotliWriter(rw.handler.Params, rw.ResponseWriter)
+		}
+	}
+
+	rw.ResponseWriter.WriteHeader(rw.status)
+	if rw.writer != nil && rw.dictionary != nil {
+		if err := WriteDictionaryHeader(rw.ResponseWriter, rw.dictionary.Hash); err != nil {
+			return err
+		}
+	}
+
+	buffer := rw.buffer
+	rw.buffer = nil
+	if len(buffer) == 0 {
+		return nil
+	}
+	if rw.writer != nil {
+		_, err := rw.writer.Write(buffer)
+		return err
+	}
+	_, err := rw.ResponseWriter.Write(buffer)
+	return err
+}
+
+// Marks the response as encoded. Its ETag is changed so that caches don't
+// confuse it with the unencoded representation.
+func setContentEncoding(header http.Header, encoding string) {
+	header.Set("Content-Encoding", encoding)
+	header.Del("Accept-Ranges")
+	if etag := header.Get("Etag"); etag != "" {
+		header.Set("Etag", encodedETag(etag, encoding))
+	}
+}
+
+// Checks the status and headers which rule out compression
+func (rw *responseWriter) shouldCompress() bool {
+	header := rw.Header()
+	if rw.status < http.StatusOK || rw.status == http.StatusNoContent ||
+		rw.status == http.StatusNotModified || rw.status == http.StatusPartialContent {
+		return false
+	}
+	if encoding := header.Get("Content-Encoding"); encoding != "" && encoding != "identity" {
+		return false
+	}
+	if length, err := strconv.Atoi(header.Get("Content-Length")); err == nil && length < rw.handler.MinSize {
+		return false
+	}
+	return !rw.handler.excluded(header.Get("Content-Type"))
+}
@@ -0,0 +1,224 @@
+package brotlihttp
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gopkg.in/kothar/brotli-go.v0/dec"
+)
+
+var testBody = strings.Repeat("The quick brown fox jumps over the lazy dog. ", 1000)
+
+func TestAcceptsBrotli(T *testing.T) {
+	tests := []struct {
+		header string
+		accept bool
+	}{
+		{"", false},
+		{"gzip, deflate", false},
+		{"gzip, deflate, br", true},
+		{"BR", true},
+		{"br;q=0", false},
+		{"br; q=0.5, gzip;q=1", true},
+		{"br;q=0.000", false},
+		{"br;q=invalid", false},
+		{"*", true},
+		{"*;q=0", false},
+		{"*, br;q=0", false},
+		{"br;q=0, *", false},
+		{"gzip;q=0, *;q=0.1", true},
+	}
+
+	for _, test := range tests {
+		r, _ := http.NewRequest("GET", "/", nil)
+		if test.header != "" {
+			r.Header.Set("Accept-Encoding", test.header)
+		}
+		if accept := AcceptsBrotli(r); accept != test.accept {
+			T.Errorf("AcceptsBrotli(%q) = %v, expected %v", test.header, accept, test.accept)
+		}
+	}
+}
+
+func serve(handler http.Handler, acceptEncoding string) *httptest.ResponseRecorder {
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept-Encoding", acceptEncoding)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestHandler(T *testing.T) {
+	handler := NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "45000")
+		for i := 0; i < 1000; i++ {
+			w.Write([]byte("The quick brown fox jumps over the lazy dog. "))
+		}
+	}), nil)
+
+	w := serve(handler, "gzip, br")
+	if w.Header().Get("Content-Encoding") != "br" {
+		T.Fatalf("Expected br Content-Encoding, got %q", w.Header().Get("Content-Encoding"))
+	}
+	if w.Header().Get("Vary") != "Accept-Encoding" {
+		T.Errorf("Expected Vary: Accept-Encoding, got %q", w.Header().Get("Vary"))
+	}
+	if w.Header().Get("Content-Length") != "" {
+		T.Errorf("Expected Content-Length to be removed, got %q", w.Header().Get("Content-Length"))
+	}
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		T.Errorf("Expected sniffed Content-Type, got %q", w.Header().Get("Content-Type"))
+	}
+
+	decoded, err := dec.DecompressBuffer(w.Body.Bytes(), nil)
+	if err != nil {
+		T.Fatal(err)
+	}
+	if string(decoded) != testBody {
+		T.Error("Decoded response does not match original")
+	}
+
+	// Not accepted
+	w = serve(handler, "gzip")
+	if w.Header().Get("Content-Encoding") != "" || w.Body.String() != testBody {
+		T.Error("Expected uncompressed response")
+	}
+	if w.Header().Get("Vary") != "Accept-Encoding" {
+		T.Errorf("Expected Vary: Accept-Encoding, got %q", w.Header().Get("Vary"))
+	}
+}
+
+func TestHandlerSkips(T *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{"small", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("Hello"))
+		}},
+		{"encoded", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write([]byte(testBody))
+		}},
+		{"excluded type", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "video/mp4")
+			w.Write([]byte(testBody))
+		}},
+		{"partial content", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write([]byte(testBody))
+		}},
+		{"no content", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}},
+	}
+
+	for _, test := range tests {
+		w := serve(NewHandler(test.handler, nil), "br")
+		if w.Header().Get("Content-Encoding") == "br" {
+			T.Errorf("%s: Expected response not to be compressed", test.name)
+		}
+	}
+
+	// The size of small responses is known
+	w := serve(NewHandler(tests[0].handler, nil), "br")
+	if w.Header().Get("Content-Length") != "5" || w.Body.String() != "Hello" {
+		T.Errorf("Expected Content-Length 5, got %q", w.Header().Get("Content-Length"))
+	}
+}
+
+func TestHandlerFlush(T *testing.T) {
+	flushed := make(chan []byte, 1)
+	handler := NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: 1\n\n"))
+		w.(http.Flusher).Flush()
+
+		recorder := w.(*responseWriter).ResponseWriter.(*httptest.ResponseRecorder)
+		flushed <- append([]byte(nil), recorder.Body.Bytes()...)
+
+		w.Write([]byte("data: 2\n\n"))
+	}), nil)
+
+	w := serve(handler, "br")
+
+	// Everything written before the flush can be decoded
+	reader := dec.NewBrotliReader(bytes.NewReader(<-flushed))
+	event := make([]byte, 9)
+	if _, err := reader.Read(event); err != nil || string(event) != "data: 1\n\n" {
+		T.Errorf("Expected first event after flush, got %q, %v", event, err)
+	}
+	reader.Close()
+
+	if w.Header().Get("Content-Encoding") != "br" || !w.Flushed {
+		T.Fatal("Expected compressed and flushed response")
+	}
+	decoded, err := dec.DecompressBuffer(w.Body.Bytes(), nil)
+	if err != nil {
+		T.Fatal(err)
+	}
+	if string(decoded) != "data: 1\n\ndata: 2\n\n" {
+		T.Errorf("Unexpected response %q", decoded)
+	}
+}
+
+func TestHandlerPanic(T *testing.T) {
+	handler := NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testBody))
+		panic("handler failed")
+	}), nil)
+
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept-Encoding", "br")
+	w := httptest.NewRecorder()
+	func() {
+		defer func() {
+			if recover() == nil {
+				T.Error("Expected the panic to be passed on")
+			}
+		}()
+		handler.ServeHTTP(w, r)
+	}()
+
+	if _, err := dec.DecompressBuffer(w.Body.Bytes(), nil); err == nil {
+		T.Error("Expected the response of a panicking handler not to be a complete stream")
+	}
+}
+
+func TestHandlerETag(T *testing.T) {
+	handler := NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Etag", `"abc"`)
+		w.Write([]byte(testBody))
+	}), nil)
+
+	// Streamed
+	if etag := serve(handler, "br").Header().Get("Etag"); etag != `"abc-br"` {
+		T.Errorf("Expected ETag \"abc-br\" for a streamed response, got %q", etag)
+	}
+	if etag := serve(handler, "gzip").Header().Get("Etag"); etag != `"abc"` {
+		T.Errorf("Expected ETag \"abc\" for an uncompressed response, got %q", etag)
+	}
+
+	// From the cache
+	handler.Cache = NewCache(1 << 20)
+	if etag := serve(handler, "br").Header().Get("Etag"); etag != `"abc-br"` {
+		T.Errorf("Expected ETag \"abc-br\" for a cached response, got %q", etag)
+	}
+	handler.Cache = nil
+
+	// With a dictionary
+	d := NewDictionary(testDictionary, "/*")
+	handler.Dictionaries = NewDictionaries()
+	handler.Dictionaries.Add(d)
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept-Encoding", "br, dcb")
+	r.Header.Set("Available-Dictionary", availableDictionary(d))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if etag := w.Header().Get("Etag"); etag != `"abc-dcb"` {
+		T.Errorf("Expected ETag \"abc-dcb\" for a dictionary response, got %q", etag)
+	}
+}
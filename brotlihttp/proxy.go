@@ -0,0 +1,222 @@
+package brotlihttp
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/kothar/brotli-go.v0/enc"
+)
+
+// DefaultFlushInterval is how long a Recompressor waits for more data from
+// upstream before flushing the compressed output by default.
+const DefaultFlushInterval = 100 * time.Millisecond
+
+// The size of the buffer used to read upstream responses
+const recompressBufferSize = 32 << 10
+
+// Recompressor re-encodes upstream responses with Brotli, for use as the
+// ModifyResponse function of an httputil.ReverseProxy:
+//
+//	proxy.ModifyResponse = brotlihttp.NewRecompressor(nil).ModifyResponse
+//
+// Responses which are gzip or deflate encoded are decoded, and responses
+// without a Content-Encoding are compressed directly, for clients which
+// accept br. Responses with other encodings, without a Content-Type, smaller
+// than MinSize, or of an excluded type are left alone.
+//
+// The response is recompressed as it streams through the proxy. Whenever the
+// upstream response pauses for FlushInterval, everything received so far is
+// compressed and flushed, so that streamed responses keep working.
+type Recompressor struct {
+	// Params are the compression parameters. If nil, the encoder defaults
+	// are used, whose quality of 11 is too slow to keep up with most
+	// upstream responses; NewRecompressor sets DefaultQuality instead.
+	Params *enc.BrotliParams
+
+	// MinSize is the Content-Length below which responses are not
+	// recompressed. Responses of unknown length are always recompressed.
+	MinSize int
+
+	// ExcludedTypes lists the media types which are not recompressed.
+	// Entries ending in "/", such as "video/", exclude all subtypes.
+	ExcludedTypes []string
+
+	// FlushInterval is how long to wait for more data from upstream before
+	// flushing the compressed output. If zero or negative, the output is
+	// flushed after each read from upstream.
+	FlushInterval time.Duration
+}
+
+// NewRecompressor returns a Recompressor which uses the provided compression
+// parameters, with the default minimum size, excluded content types and
+// flush interval. DefaultQuality is used if params is nil.
+func NewRecompressor(params *enc.BrotliParams) *Recompressor {
+	if params == nil {
+		params = enc.NewBrotliParams()
+		params.SetQuality(DefaultQuality)
+	}
+
+	return &Recompressor{
+		Params:        params,
+		MinSize:       DefaultMinSize,
+		ExcludedTypes: DefaultExcludedTypes,
+		FlushInterval: DefaultFlushInterval,
+	}
+}
+
+// ModifyResponse recompresses the body of resp with Brotli if the request it
+// answers accepts br, and the response is suitable. The Content-Encoding,
+// Content-Length, Accept-Ranges, ETag and Vary headers are updated to match.
+func (c *Recompressor) ModifyResponse(resp *http.Response) error {
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	var newDecoder func(io.Reader) (io.Reader, error)
+	switch encoding {
+	case "", "identity":
+	case "gzip", "x-gzip":
+		newDecoder = func(r io.Reader) (io.Reader, error) {
+			return gzip.NewReader(r)
+		}
+	case "deflate":
+		newDecoder = newDeflateReader
+	default:
+		return nil
+	}
+
+	if !c.shouldCompress(resp) {
+		return nil
+	}
+	addVary(resp.Header, "Accept-Encoding")
+	if resp.Request == nil || !AcceptsBrotli(resp.Request) || resp.Request.Method == "HEAD" {
+		return nil
+	}
+
+	header := resp.Header
+	header.Set("Content-Encoding", Encoding)
+	header.Del("Content-Length")
+	header.Del("Accept-Ranges")
+	if etag := header.Get("Etag"); etag != "" {
+		header.Set("Etag", encodedETag(etag, Encoding))
+	}
+	resp.ContentLength = -1
+
+	reader, writer := io.Pipe()
+	go c.recompress(resp.Body, newDecoder, writer)
+	resp.Body = &recompressedBody{PipeReader: reader, body: resp.Body}
+	return nil
+}
+
+// Checks the status and headers which rule out recompression
+func (c *Recompressor) shouldCompress(resp *http.Response) bool {
+	if resp.Body == nil || resp.StatusCode < http.StatusOK || resp.StatusCode == http.StatusNoContent ||
+		resp.StatusCode == http.StatusNotModified || resp.StatusCode == http.StatusPartialContent {
+		return false
+	}
+	if length, err := strconv.Atoi(resp.Header.Get("Content-Length")); err == nil && length < c.MinSize {
+		return false
+	}
+
+	// The content type would otherwise be sniffed from the compressed data
+	contentType := resp.Header.Get("Content-Type")
+	return contentType != "" && !excludedType(c.ExcludedTypes, contentType)
+}
+
+// Decodes the upstream body and compresses it into the pipe, flushing when
+// the upstream body pauses
+func (c *Recompressor) recompress(body io.Reader, newDecoder func(io.Reader) (io.Reader, error), pipe *io.PipeWriter) {
+	writer := enc.NewBrotliWriter(c.Params, pipe)
+
+	var mutex sync.Mutex
+	closed := false
+	timer := time.AfterFunc(time.Hour, func() {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if !closed {
+			writer.Flush()
+		}
+	})
+	timer.Stop()
+
+	err := func() error {
+		decoded := body
+		if newDecoder != nil {
+			var err error
+			if decoded, err = newDecoder(body); err != nil {
+				return err
+			}
+		}
+
+		buffer := make([]byte, recompressBufferSize)
+		for {
+			n, err := decoded.Read(buffer)
+			if n > 0 {
+				mutex.Lock()
+				_, writeErr := writer.Write(buffer[:n])
+				if writeErr == nil && c.FlushInterval <= 0 {
+					writeErr = writer.Flush()
+				}
+				mutex.Unlock()
+				if writeErr != nil {
+					return writeErr
+				}
+				if c.FlushInterval > 0 {
+					timer.Reset(c.FlushInterval)
+				}
+			}
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	timer.Stop()
+	closed = true
+
+	// Make sure the stream can't be mistaken for a complete one, then free
+	// the encoder
+	if err != nil {
+		pipe.CloseWithError(err)
+		writer.Close()
+	} else {
+		err = writer.Close()
+	}
+
+	// Closing the encoder closes the pipe unless it failed to write the end of
+	// the stream, which the client must see as an error rather than EOF
+	pipe.CloseWithError(err)
+}
+
+// Reads the recompressed response, and closes the upstream response with it
+type recompressedBody struct {
+	*io.PipeReader
+	body io.ReadCloser
+}
+
+// Close stops the recompression and closes the upstream response body.
+func (b *recompressedBody) Close() error {
+	b.PipeReader.Close()
+	return b.body.Close()
+}
+
+// Decodes the deflate content encoding, which should be zlib format but is
+// sent as raw deflate data by some servers
+func newDeflateReader(r io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(r)
+	header, err := buffered.Peek(2)
+	if err == nil && header[0]&0x0f == 8 && (uint(header[0])<<8|uint(header[1]))%31 == 0 {
+		return zlib.NewReader(buffered)
+	}
+	return flate.NewReader(buffered), nil
+}
@@ -0,0 +1,143 @@
+package brotlihttp
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"gopkg.in/kothar/brotli-go.v0/dec"
+)
+
+func upstreamResponse(acceptEncoding, contentEncoding string, body io.Reader) *http.Response {
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+	resp := &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        http.Header{"Content-Type": {"text/plain"}, "Etag": {`"1234"`}},
+		Body:          ioutil.NopCloser(body),
+		ContentLength: -1,
+		Request:       req,
+	}
+	if contentEncoding != "" {
+		resp.Header.Set("Content-Encoding", contentEncoding)
+	}
+	return resp
+}
+
+func TestRecompressor(T *testing.T) {
+	var gzipped, zlibbed, deflated bytes.Buffer
+	gzipWriter := gzip.NewWriter(&gzipped)
+	gzipWriter.Write([]byte(testBody))
+	gzipWriter.Close()
+	zlibWriter := zlib.NewWriter(&zlibbed)
+	zlibWriter.Write([]byte(testBody))
+	zlibWriter.Close()
+	flateWriter, _ := flate.NewWriter(&deflated, flate.DefaultCompression)
+	flateWriter.Write([]byte(testBody))
+	flateWriter.Close()
+
+	tests := []struct {
+		encoding string
+		body     []byte
+	}{
+		{"", []byte(testBody)},
+		{"gzip", gzipped.Bytes()},
+		{"deflate", zlibbed.Bytes()},
+		{"deflate", deflated.Bytes()},
+	}
+
+	recompressor := NewRecompressor(nil)
+	for _, test := range tests {
+		resp := upstreamResponse("gzip, deflate, br", test.encoding, bytes.NewReader(test.body))
+		if err := recompressor.ModifyResponse(resp); err != nil {
+			T.Fatal(err)
+		}
+		if resp.Header.Get("Content-Encoding") != "br" || resp.Header.Get("Vary") != "Accept-Encoding" ||
+			resp.Header.Get("Etag") != `"1234-br"` || resp.ContentLength != -1 {
+			T.Errorf("%q: Unexpected headers %v", test.encoding, resp.Header)
+		}
+
+		compressed, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			T.Fatal(err)
+		}
+		decoded, err := dec.DecompressBuffer(compressed, nil)
+		if err != nil {
+			T.Fatal(err)
+		}
+		if string(decoded) != testBody {
+			T.Errorf("%q: Decoded response does not match original", test.encoding)
+		}
+	}
+
+	// Not accepted
+	resp := upstreamResponse("gzip", "gzip", bytes.NewReader(gzipped.Bytes()))
+	recompressor.ModifyResponse(resp)
+	if resp.Header.Get("Content-Encoding") != "gzip" || resp.Header.Get("Vary") != "Accept-Encoding" {
+		T.Errorf("Expected gzip response to be left alone, got %v", resp.Header)
+	}
+
+	// Unsuitable
+	resp = upstreamResponse("br", "compress", bytes.NewReader(nil))
+	recompressor.ModifyResponse(resp)
+	if resp.Header.Get("Content-Encoding") != "compress" {
+		T.Error("Expected unknown encoding to be left alone")
+	}
+	resp = upstreamResponse("br", "", bytes.NewReader(nil))
+	resp.Header.Set("Content-Type", "image/png")
+	recompressor.ModifyResponse(resp)
+	if resp.Header.Get("Content-Encoding") != "" {
+		T.Error("Expected excluded type to be left alone")
+	}
+}
+
+func TestRecompressorCorrupt(T *testing.T) {
+	resp := upstreamResponse("br", "gzip", bytes.NewReader([]byte("this is not gzip data")))
+	NewRecompressor(nil).ModifyResponse(resp)
+	defer resp.Body.Close()
+
+	if _, err := ioutil.ReadAll(resp.Body); err != gzip.ErrHeader {
+		T.Errorf("Expected gzip.ErrHeader, got %v", err)
+	}
+}
+
+func TestRecompressorFlush(T *testing.T) {
+	upstream, upstreamWriter := io.Pipe()
+	gzipWriter := gzip.NewWriter(upstreamWriter)
+	next := make(chan bool)
+	go func() {
+		gzipWriter.Write([]byte("data: 1\n\n"))
+		gzipWriter.Flush()
+		<-next
+		gzipWriter.Write([]byte("data: 2\n\n"))
+		gzipWriter.Close()
+		upstreamWriter.Close()
+	}()
+
+	resp := upstreamResponse("br", "gzip", upstream)
+	recompressor := NewRecompressor(nil)
+	recompressor.FlushInterval = 10 * time.Millisecond
+	recompressor.ModifyResponse(resp)
+	defer resp.Body.Close()
+
+	// The first event can be decoded while the upstream response is open
+	reader := dec.NewBrotliReader(resp.Body)
+	defer reader.Close()
+	event := make([]byte, 9)
+	if _, err := io.ReadFull(reader, event); err != nil || string(event) != "data: 1\n\n" {
+		T.Errorf("Expected first event after flush, got %q, %v", event, err)
+	}
+
+	next <- true
+	rest, err := ioutil.ReadAll(reader)
+	if err != nil || string(rest) != "data: 2\n\n" {
+		T.Errorf("Expected second event, got %q, %v", rest, err)
+	}
+}
@@ -0,0 +1,196 @@
+package brotlihttp
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"gopkg.in/kothar/brotli-go.v0/dec"
+)
+
+// DefaultMaxDecodedSize is the default limit on the decoded size of request
+// bodies.
+const DefaultMaxDecodedSize = 10 << 20
+
+// DefaultMaxRatio is the default limit on the ratio of decoded to compressed
+// size of request bodies.
+const DefaultMaxRatio = 100
+
+// The decoded size below which the ratio is not checked, as small bodies can
+// legitimately compress very well
+const minRatioCheckSize = 64 << 10
+
+var (
+	// ErrRequestTooLarge is returned when reading a request body which
+	// decodes to more than MaxDecodedSize bytes
+	ErrRequestTooLarge = errors.New("brotlihttp: decoded request body too large")
+
+	// ErrRequestRatioExceeded is returned when reading a request body which
+	// decodes to more than MaxRatio times its compressed size
+	ErrRequestRatioExceeded = errors.New("brotlihttp: request body compression ratio too high")
+)
+
+// RequestDecoder wraps another http.Handler, decoding the bodies of requests
+// sent with Content-Encoding: br. The Content-Encoding and Content-Length
+// headers are removed from decoded requests, and their ContentLength is set
+// to -1. Other requests are passed on unchanged.
+//
+// If reading the body fails because it is corrupt, RequestDecoder answers
+// 400 Bad Request, or 413 Request Entity Too Large if a limit was exceeded,
+// in place of whatever the wrapped handler responds with, as long as the
+// handler has not started its response before reading the body.
+type RequestDecoder struct {
+	// Handler is the wrapped handler
+	Handler http.Handler
+
+	// MaxDecodedSize limits the decoded size of request bodies. A limit of 0
+	// means no limit.
+	MaxDecodedSize int64
+
+	// MaxRatio limits how many times larger than its compressed size a
+	// request body may decode to, which rejects decompression bombs before
+	// MaxDecodedSize is reached. A limit of 0 means no limit.
+	MaxRatio int64
+}
+
+// NewRequestDecoder returns a RequestDecoder which decodes requests for h,
+// with the default limits.
+func NewRequestDecoder(h http.Handler) *RequestDecoder {
+	return &RequestDecoder{
+		Handler:        h,
+		MaxDecodedSize: DefaultMaxDecodedSize,
+		MaxRatio:       DefaultMaxRatio,
+	}
+}
+
+func (d *RequestDecoder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.EqualFold(strings.TrimSpace(r.Header.Get("Content-Encoding")), Encoding) || r.Body == nil {
+		d.Handler.ServeHTTP(w, r)
+		return
+	}
+
+	reader := dec.NewBrotliReader(r.Body)
+	reader.SetMaxOutput(d.MaxDecodedSize)
+	defer reader.Close()
+
+	body := &requestBody{
+		body:     r.Body,
+		reader:   reader,
+		maxRatio: d.MaxRatio,
+	}
+
+	// A handler must not modify the request
+	decodedReq := new(http.Request)
+	*decodedReq = *r
+	decodedReq.Header = make(http.Header, len(r.Header))
+	for key, values := range r.Header {
+		decodedReq.Header[key] = values
+	}
+	decodedReq.Header.Del("Content-Encoding")
+	decodedReq.Header.Del("Content-Length")
+	decodedReq.ContentLength = -1
+	decodedReq.Body = body
+
+	rw := &requestResponseWriter{ResponseWriter: w, body: body}
+	d.Handler.ServeHTTP(rw, decodedReq)
+	if !rw.wroteHeader && body.err != nil {
+		rw.fail()
+	}
+}
+
+// Decodes a request body, enforcing the limits and remembering the first error
+type requestBody struct {
+	body     io.ReadCloser
+	reader   *dec.BrotliReader
+	decoded  int64
+	maxRatio int64
+	err      error
+}
+
+func (b *requestBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+
+	n, err := b.reader.Read(p)
+	b.decoded += int64(n)
+	if err == dec.ErrOutputLimitExceeded {
+		err = ErrRequestTooLarge
+	} else if err == nil && b.maxRatio > 0 && b.decoded > minRatioCheckSize && b.decoded > b.maxRatio*b.reader.InputOffset() {
+		// The decoder reads ahead, so the ratio is based on the compressed
+		// data it has consumed rather than read
+		err = ErrRequestRatioExceeded
+	}
+
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+	return n, err
+}
+
+// Close closes the request body. The decoder is released when the wrapped
+// handler returns.
+func (b *requestBody) Close() error {
+	return b.body.Close()
+}
+
+// Replaces the response with an error if decoding the request body failed
+// before the response was started
+type requestResponseWriter struct {
+	http.ResponseWriter
+	body        *requestBody
+	wroteHeader bool
+	failed      bool // whether the handler's response is being discarded
+}
+
+func (rw *requestResponseWriter) WriteHeader(status int) {
+	if rw.wroteHeader {
+		return
+	}
+	rw.wroteHeader = true
+
+	if rw.body.err != nil {
+		rw.fail()
+		return
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *requestResponseWriter) Write(p []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.wroteHeader = true
+		if rw.body.err != nil {
+			rw.fail()
+		}
+	}
+
+	if rw.failed {
+		return len(p), nil
+	}
+	return rw.ResponseWriter.Write(p)
+}
+
+// Flush implements the http.Flusher interface.
+func (rw *requestResponseWriter) Flush() {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok && !rw.failed {
+		flusher.Flush()
+	}
+}
+
+// Sends an error response describing why the request body couldn't be read
+func (rw *requestResponseWriter) fail() {
+	rw.failed = true
+	status := http.StatusBadRequest
+	if rw.body.err == ErrRequestTooLarge || rw.body.err == ErrRequestRatioExceeded {
+		status = http.StatusRequestEntityTooLarge
+	}
+
+	header := rw.Header()
+	header.Del("Content-Encoding")
+	header.Del("Content-Length")
+	http.Error(rw.ResponseWriter, rw.body.err.Error(), status)
+}
@@ -0,0 +1,137 @@
+package brotlihttp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"gopkg.in/kothar/brotli-go.v0/enc"
+)
+
+// Echoes the request body, or reports the error reading it
+var echoHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "handler error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("X-Content-Encoding", r.Header.Get("Content-Encoding"))
+	w.Write(body)
+})
+
+func post(handler http.Handler, body []byte, contentEncoding string) *httptest.ResponseRecorder {
+	r, _ := http.NewRequest("POST", "/", bytes.NewReader(body))
+	if contentEncoding != "" {
+		r.Header.Set("Content-Encoding", contentEncoding)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func compress(T *testing.T, data []byte) []byte {
+	compressed, err := enc.CompressBuffer(nil, data, nil)
+	if err != nil {
+		T.Fatal(err)
+	}
+	return compressed
+}
+
+// Creates JSON-like data which compresses at a realistic ratio
+func randomRecords(size int) []byte {
+	random := rand.New(rand.NewSource(1))
+	var buffer bytes.Buffer
+	for buffer.Len() < size {
+		buffer.WriteString(`{"id":`)
+		buffer.WriteString(strconv.Itoa(random.Intn(100000)))
+		buffer.WriteString(`,"value":"`)
+		for i := 0; i < 16; i++ {
+			buffer.WriteByte(byte('a' + random.Intn(26)))
+		}
+		buffer.WriteString("\"},\n")
+	}
+	return buffer.Bytes()
+}
+
+func TestRequestDecoder(T *testing.T) {
+	handler := NewRequestDecoder(echoHandler)
+	data := randomRecords(200000)
+
+	w := post(handler, compress(T, data), "br")
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), data) {
+		T.Fatalf("Expected decoded request, got %d %q", w.Code, w.Body.String())
+	}
+	if w.Header().Get("X-Content-Encoding") != "" {
+		T.Error("Expected Content-Encoding to be removed from the request")
+	}
+
+	// Other requests are passed on unchanged
+	w = post(handler, data, "")
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), data) {
+		T.Errorf("Expected unchanged request, got %d", w.Code)
+	}
+	w = post(handler, []byte("gzipped"), "gzip")
+	if w.Body.String() != "gzipped" || w.Header().Get("X-Content-Encoding") != "gzip" {
+		T.Errorf("Expected unchanged request, got %q", w.Body.String())
+	}
+}
+
+func TestRequestDecoderErrors(T *testing.T) {
+	compressed := compress(T, []byte(testBody))
+
+	tests := []struct {
+		name    string
+		handler *RequestDecoder
+		body    []byte
+		status  int
+		message string
+	}{
+		{"corrupt", NewRequestDecoder(echoHandler), []byte("not brotli"), http.StatusBadRequest, "Brotli decompression error"},
+		{"truncated", NewRequestDecoder(echoHandler), compressed[:len(compressed)/2], http.StatusBadRequest, "unexpected EOF"},
+		{"too large", &RequestDecoder{Handler: echoHandler, MaxDecodedSize: 1000}, compressed, http.StatusRequestEntityTooLarge, ErrRequestTooLarge.Error()},
+		{"ratio", &RequestDecoder{Handler: echoHandler, MaxRatio: 10}, compress(T, make([]byte, 1<<20)), http.StatusRequestEntityTooLarge, ErrRequestRatioExceeded.Error()},
+	}
+
+	for _, test := range tests {
+		w := post(test.handler, test.body, "br")
+		if w.Code != test.status || !strings.Contains(w.Body.String(), test.message) {
+			T.Errorf("%s: Expected %d %q, got %d %q", test.name, test.status, test.message, w.Code, w.Body.String())
+		}
+	}
+
+	// The ratio is checked against the compressed data consumed so far, so a
+	// bomb is caught early even when more of the body has been read
+	bombParams := enc.NewBrotliParams()
+	bombParams.SetQuality(1)
+	bomb, err := enc.CompressBuffer(bombParams, make([]byte, 32<<20), nil)
+	if err != nil {
+		T.Fatal(err)
+	}
+	bomb = append(bomb, randomRecords(100000)...)
+	var read int
+	countingHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buffer := make([]byte, 4096)
+		for {
+			n, err := r.Body.Read(buffer)
+			read += n
+			if err != nil {
+				return
+			}
+		}
+	})
+	w := post(&RequestDecoder{Handler: countingHandler, MaxRatio: 100}, bomb, "br")
+	if w.Code != http.StatusRequestEntityTooLarge || read > 1<<20 {
+		T.Errorf("Expected bomb to be rejected early, got %d after %d bytes", w.Code, read)
+	}
+
+	// Without limits
+	w = post(&RequestDecoder{Handler: echoHandler}, compress(T, make([]byte, 1<<20)), "br")
+	if w.Code != http.StatusOK || w.Body.Len() != 1<<20 {
+		T.Errorf("Expected decoded request, got %d", w.Code)
+	}
+}
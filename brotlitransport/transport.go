@@ -0,0 +1,113 @@
+// Package brotlitransport provides an http.RoundTripper which transparently
+// decodes Brotli-encoded responses
+package brotlitransport // import "gopkg.in/kothar/brotli-go.v0/brotlitransport"
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+
+	"gopkg.in/kothar/brotli-go.v0/dec"
+)
+
+// Transport is an http.RoundTripper which asks for Brotli and gzip encoded
+// responses, and decodes them transparently in the same way that
+// http.Transport decodes gzip.
+//
+// Encodings are only requested, and responses decoded, if the request has no
+// Accept-Encoding or Range header and is not a HEAD request. The
+// Content-Encoding and Content-Length headers are removed from decoded
+// responses, the response ContentLength is set to -1, and from Go 1.7 the
+// response is marked as Uncompressed.
+type Transport struct {
+	// Base is the RoundTripper used to make requests. If nil,
+	// http.DefaultTransport is used.
+	Base http.RoundTripper
+
+	// MaxDecodedSize limits the size of decoded Brotli response bodies.
+	// Reading more than MaxDecodedSize bytes fails with
+	// dec.ErrOutputLimitExceeded. A limit of 0 means no limit.
+	MaxDecodedSize int64
+}
+
+// The Accept-Encoding header added to requests
+const acceptEncoding = "br, gzip"
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	if req.Header.Get("Accept-Encoding") != "" || req.Header.Get("Range") != "" || req.Method == "HEAD" {
+		return base.RoundTrip(req)
+	}
+
+	// A RoundTripper must not modify the request
+	encodedReq := new(http.Request)
+	*encodedReq = *req
+	encodedReq.Header = make(http.Header, len(req.Header)+1)
+	for key, values := range req.Header {
+		encodedReq.Header[key] = values
+	}
+	encodedReq.Header.Set("Accept-Encoding", acceptEncoding)
+
+	resp, err := base.RoundTrip(encodedReq)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(resp.Header.Get("Content-Encoding")) {
+	case "br":
+		reader := dec.NewBrotliReader(resp.Body)
+		reader.SetMaxOutput(t.MaxDecodedSize)
+		resp.Body = &decodedBody{body: resp.Body, reader: reader, closer: reader}
+	case "gzip":
+		resp.Body = &decodedBody{body: resp.Body}
+	default:
+		return resp, nil
+	}
+
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	setUncompressed(resp)
+	return resp, nil
+}
+
+// Decodes a response body, and closes the decoder with the body
+type decodedBody struct {
+	body   io.ReadCloser
+	reader io.Reader
+	closer io.Closer // releases the decoder, if needed
+	err    error
+}
+
+func (b *decodedBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+
+	// Like http.Transport, wait for the first Read to start decoding gzip,
+	// as it reads the header straight away
+	if b.reader == nil {
+		reader, err := gzip.NewReader(b.body)
+		if err != nil {
+			b.err = err
+			return 0, err
+		}
+		b.reader = reader
+	}
+
+	return b.reader.Read(p)
+}
+
+// Close releases the decoder and closes the response body.
+func (b *decodedBody) Close() error {
+	if b.closer != nil {
+		b.closer.Close()
+	}
+	return b.body.Close()
+}
@@ -0,0 +1,110 @@
+package brotlitransport
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gopkg.in/kothar/brotli-go.v0/brotlihttp"
+	"gopkg.in/kothar/brotli-go.v0/dec"
+)
+
+var testBody = strings.Repeat("The quick brown fox jumps over the lazy dog. ", 1000)
+
+func newServer() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/br", brotlihttp.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testBody))
+	}), nil))
+	mux.HandleFunc("/gzip", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		writer := gzip.NewWriter(w)
+		writer.Write([]byte(testBody))
+		writer.Close()
+	})
+	mux.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("Accept-Encoding")))
+	})
+	return httptest.NewServer(mux)
+}
+
+func get(client *http.Client, url string, acceptEncoding string) (*http.Response, string, error) {
+	req, _ := http.NewRequest("GET", url, nil)
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	return resp, string(body), err
+}
+
+func TestTransport(T *testing.T) {
+	server := newServer()
+	defer server.Close()
+	client := &http.Client{Transport: &Transport{}}
+
+	for _, path := range []string{"/br", "/gzip"} {
+		resp, body, err := get(client, server.URL+path, "")
+		if err != nil {
+			T.Fatal(err)
+		}
+		if body != testBody {
+			T.Errorf("%s: Decoded response does not match original", path)
+		}
+		if resp.Header.Get("Content-Encoding") != "" || resp.Header.Get("Content-Length") != "" || resp.ContentLength != -1 {
+			T.Errorf("%s: Expected Content-Encoding and Content-Length to be removed", path)
+		}
+	}
+
+	_, body, err := get(client, server.URL+"/echo", "")
+	if err != nil {
+		T.Fatal(err)
+	}
+	if body != "br, gzip" {
+		T.Errorf("Expected br to be requested, got %q", body)
+	}
+}
+
+func TestTransportExplicitEncoding(T *testing.T) {
+	server := newServer()
+	defer server.Close()
+	client := &http.Client{Transport: &Transport{}}
+
+	// The response is left alone if the request asked for an encoding itself
+	resp, body, err := get(client, server.URL+"/br", "br")
+	if err != nil {
+		T.Fatal(err)
+	}
+	if resp.Header.Get("Content-Encoding") != "br" {
+		T.Fatal("Expected encoded response")
+	}
+	decoded, err := dec.DecompressBuffer([]byte(body), nil)
+	if err != nil {
+		T.Fatal(err)
+	}
+	if string(decoded) != testBody {
+		T.Error("Decoded response does not match original")
+	}
+}
+
+func TestTransportMaxDecodedSize(T *testing.T) {
+	server := newServer()
+	defer server.Close()
+	client := &http.Client{Transport: &Transport{MaxDecodedSize: 1000}}
+
+	_, body, err := get(client, server.URL+"/br", "")
+	if err != dec.ErrOutputLimitExceeded {
+		T.Errorf("Expected dec.ErrOutputLimitExceeded, got %v", err)
+	}
+	if body != testBody[:1000] {
+		T.Errorf("Expected response up to the limit, got %d bytes", len(body))
+	}
+}
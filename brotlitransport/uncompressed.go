@@ -0,0 +1,11 @@
+//go:build go1.7
+// +build go1.7
+
+package brotlitransport
+
+import "net/http"
+
+// Marks a response as decoded, as http.Transport does for gzip
+func setUncompressed(resp *http.Response) {
+	resp.Uncompressed = true
+}
@@ -0,0 +1,9 @@
+//go:build !go1.7
+// +build !go1.7
+
+package brotlitransport
+
+import "net/http"
+
+// Responses have no Uncompressed field before Go 1.7
+func setUncompressed(resp *http.Response) {}
@@ -0,0 +1,33 @@
+//go:build go1.7
+// +build go1.7
+
+package brotlitransport
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestTransportUncompressed(T *testing.T) {
+	server := newServer()
+	defer server.Close()
+	client := &http.Client{Transport: &Transport{}}
+
+	for _, path := range []string{"/br", "/gzip"} {
+		resp, _, err := get(client, server.URL+path, "")
+		if err != nil {
+			T.Fatal(err)
+		}
+		if !resp.Uncompressed {
+			T.Errorf("%s: Expected the response to be marked as uncompressed", path)
+		}
+	}
+
+	resp, _, err := get(client, server.URL+"/br", "br")
+	if err != nil {
+		T.Fatal(err)
+	}
+	if resp.Uncompressed {
+		T.Error("Expected a response which was left encoded not to be marked as uncompressed")
+	}
+}
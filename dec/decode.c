@@ -40,6 +40,10 @@ static inline BrotliResult BrotliFailure(const char *f, int l, const char *fn) {
 }
 #endif
 
+/* Records why decoding failed in the state, for the Go bindings to report. */
+#define BROTLI_FAILURE_REASON(s, reason) \
+    ((s)->error_reason = (reason), BROTLI_FAILURE())
+
 #ifdef BROTLI_DECODE_DEBUG
 #define BROTLI_LOG_UINT(name)                                    \
   printf("[%s] %s = %lu\n", __func__, #name, (unsigned long)(name))
@@ -177,7 +181,7 @@ static BROTLI_NOINLINE BrotliResult DecodeVarLenUint8(BrotliState* s,
       return BROTLI_RESULT_SUCCESS;
 
     default:
-      return BROTLI_FAILURE();
+      return BROTLI_FAILURE_REASON(s, "unexpected decoder state");
   }
 }
 
@@ -236,7 +240,7 @@ static BrotliResult BROTLI_NOINLINE DecodeMetaBlockLength(BrotliState* s,
             return BROTLI_RESULT_NEEDS_MORE_INPUT;
           }
           if (i + 1 == s->size_nibbles && s->size_nibbles > 4 && bits == 0) {
-            return BROTLI_FAILURE();
+            return BROTLI_FAILURE_REASON(s, "invalid meta-block length");
           }
           s->meta_block_remaining_len |= (int)(bits << (i * 4));
         }
@@ -260,7 +264,7 @@ static BrotliResult BROTLI_NOINLINE DecodeMetaBlockLength(BrotliState* s,
           return BROTLI_RESULT_NEEDS_MORE_INPUT;
         }
         if (bits != 0) {
-          return BROTLI_FAILURE();
+          return BROTLI_FAILURE_REASON(s, "reserved bit set in meta-block header");
         }
         s->substate_metablock_header = BROTLI_STATE_METABLOCK_HEADER_BYTES;
         /* No break, transit to the next state. */
@@ -285,7 +289,7 @@ static BrotliResult BROTLI_NOINLINE DecodeMetaBlockLength(BrotliState* s,
             return BROTLI_RESULT_NEEDS_MORE_INPUT;
           }
           if (i + 1 == s->size_nibbles && s->size_nibbles > 1 && bits == 0) {
-            return BROTLI_FAILURE();
+            return BROTLI_FAILURE_REASON(s, "invalid metadata length");
           }
           s->meta_block_remaining_len |= (int)(bits << (i * 8));
         }
@@ -294,7 +298,7 @@ static BrotliResult BROTLI_NOINLINE DecodeMetaBlockLength(BrotliState* s,
         return BROTLI_RESULT_SUCCESS;
 
       default:
-        return BROTLI_FAILURE();
+        return BROTLI_FAILURE_REASON(s, "unexpected decoder state");
     }
   }
 }
@@ -442,7 +446,7 @@ static BrotliResult ReadSimpleHuffmanSymbols(uint32_t alphabet_size,
       return BROTLI_RESULT_NEEDS_MORE_INPUT;
     }
     if (v >= alphabet_size) {
-      return BROTLI_FAILURE();
+      return BROTLI_FAILURE_REASON(s, "invalid symbol in simple Huffman code");
     }
     s->symbols_lists_array[i] = (uint16_t)v;
     BROTLI_LOG_UINT(s->symbols_lists_array[i]);
@@ -453,7 +457,7 @@ static BrotliResult ReadSimpleHuffmanSymbols(uint32_t alphabet_size,
     uint32_t k = i + 1;
     for (; k <= num_symbols; ++k) {
       if (s->symbols_lists_array[i] == s->symbols_lists_array[k]) {
-        return BROTLI_FAILURE();
+        return BROTLI_FAILURE_REASON(s, "duplicate symbol in simple Huffman code");
       }
     }
   }
@@ -516,6 +520,7 @@ static BROTLI_INLINE void ProcessRepeatedCodeLength(uint32_t code_len,
   *repeat += repeat_delta + 3U;
   repeat_delta = *repeat - old_repeat;
   if (*symbol + repeat_delta > alphabet_size) {
+    /* Reported by ReadHuffmanCode, which checks the space left */
     (void)BROTLI_FAILURE();
     *symbol = alphabet_size;
     *space = 0xFFFFF;
@@ -666,7 +671,7 @@ static BrotliResult ReadCodeLengthCodeLengths(BrotliState* s) {
     }
   }
   if (!(num_codes == 1 || space == 0)) {
-    return BROTLI_FAILURE();
+    return BROTLI_FAILURE_REASON(s, "invalid code length code");
   }
   return BROTLI_RESULT_SUCCESS;
 }
@@ -783,7 +788,8 @@ Complex: /* Decode Huffman-coded code lengths. */
 
       if (s->space != 0) {
         BROTLI_LOG(("[ReadHuffmanCode] space = %d\n", s->space));
-        return BROTLI_FAILURE();
+        return BROTLI_FAILURE_REASON(s, s->space == 0xFFFFF ?
+            "invalid repeated code length" : "incomplete Huffman code");
       }
       table_size = BrotliBuildHuffmanTable(table, HUFFMAN_TABLE_BITS,
           s->symbol_lists, s->code_length_histo);
@@ -795,7 +801,7 @@ Complex: /* Decode Huffman-coded code lengths. */
     }
 
     default:
-      return BROTLI_FAILURE();
+      return BROTLI_FAILURE_REASON(s, "unexpected decoder state");
   }
 }
 
@@ -938,7 +944,7 @@ static BrotliResult DecodeContextMap(uint32_t context_map_size,
       BROTLI_LOG_UINT(*num_htrees);
       *context_map_arg = (uint8_t*)BROTLI_ALLOC(s, (size_t)context_map_size);
       if (*context_map_arg == 0) {
-        return BROTLI_FAILURE();
+        return BROTLI_FAILURE_REASON(s, "out of memory");
       }
       if (*num_htrees <= 1) {
         memset(*context_map_arg, 0, (size_t)context_map_size);
@@ -1007,7 +1013,7 @@ rleCode:
           reps += 1U << code;
           BROTLI_LOG_UINT(reps);
           if (context_index + reps > context_map_size) {
-            return BROTLI_FAILURE();
+            return BROTLI_FAILURE_REASON(s, "invalid context map");
           }
           do {
             context_map[context_index++] = 0;
@@ -1029,7 +1035,7 @@ rleCode:
       return BROTLI_RESULT_SUCCESS;
     }
     default:
-      return BROTLI_FAILURE();
+      return BROTLI_FAILURE_REASON(s, "unexpected decoder state");
   }
 }
 
@@ -1156,7 +1162,7 @@ static BrotliResult WriteRingBuffer(size_t* available_out, uint8_t** next_out,
     num_written = to_write;
   }
   if (s->meta_block_remaining_len < 0) {
-    return BROTLI_FAILURE();
+    return BROTLI_FAILURE_REASON(s, "output exceeds meta-block length");
   }
   memcpy(*next_out, start, num_written);
   *next_out += num_written;
@@ -1210,7 +1216,7 @@ static BrotliResult BROTLI_NOINLINE CopyUncompressedBlockToOutput(
     BrotliState* s) {
   /* TODO: avoid allocation for single uncompressed block. */
   if (!s->ringbuffer && !BrotliAllocateRingBuffer(s)) {
-    return BROTLI_FAILURE();
+    return BROTLI_FAILURE_REASON(s, "out of memory");
   }
 
   /* State machine */
@@ -1251,7 +1257,7 @@ static BrotliResult BROTLI_NOINLINE CopyUncompressedBlockToOutput(
       }
     }
   }
-  return BROTLI_FAILURE();
+  return BROTLI_FAILURE_REASON(s, "unexpected decoder state");
 }
 
 int BrotliDecompressedSize(size_t encoded_size,
@@ -1303,9 +1309,11 @@ static void BROTLI_NOINLINE BrotliCalculateRingBufferSize(BrotliState* s,
   }
 
   /* We need at least 2 bytes of ring buffer size to get the last two
-     bytes for context from there */
+     bytes for context from there. The custom dictionary counts as output
+     which must not be overwritten before the end of the stream. */
   if (is_last) {
-    while (s->ringbuffer_size >= s->meta_block_remaining_len * 2
+    while (s->ringbuffer_size >=
+        (s->meta_block_remaining_len + s->custom_dict_size) * 2
         && s->ringbuffer_size > 32) {
       s->ringbuffer_size >>= 1;
     }
@@ -1534,7 +1542,7 @@ static BROTLI_INLINE BrotliResult ProcessCommandsInternal(int safe,
   } else if (s->state == BROTLI_STATE_COMMAND_POST_WRAP_COPY) {
     goto CommandPostWrapCopy;
   } else {
-    return BROTLI_FAILURE();
+    return BROTLI_FAILURE_REASON(s, "unexpected decoder state");
   }
 
 CommandBegin:
@@ -1703,13 +1711,13 @@ postReadDistance:
                "len: %d bytes left: %d\n",
             pos, s->distance_code, i,
             s->meta_block_remaining_len));
-        return BROTLI_FAILURE();
+        return BROTLI_FAILURE_REASON(s, "invalid dictionary word transform");
       }
     } else {
       BROTLI_LOG(("Invalid backward reference. pos: %d distance: %d "
              "len: %d bytes left: %d\n", pos, s->distance_code, i,
              s->meta_block_remaining_len));
-      return BROTLI_FAILURE();
+      return BROTLI_FAILURE_REASON(s, "invalid dictionary word length");
     }
   } else {
     const uint8_t *ringbuffer_end_minus_copy_length =
@@ -1729,7 +1737,7 @@ postReadDistance:
       BROTLI_LOG(("Invalid backward reference. pos: %d distance: %d "
              "len: %d bytes left: %d\n", pos, s->distance_code, i,
              s->meta_block_remaining_len));
-      return BROTLI_FAILURE();
+      return BROTLI_FAILURE_REASON(s, "copy exceeds meta-block length");
     }
     /* There is 128+ bytes of slack in the ringbuffer allocation.
        Also, we have 16 short codes, that make these 16 bytes irrelevant
@@ -1970,7 +1978,12 @@ BrotliResult BrotliDecompressStream(size_t* available_in,
         BROTLI_LOG_UINT(s->window_bits);
         if (s->window_bits == 9) {
           /* Value 9 is reserved for future use. */
-          result = BROTLI_FAILURE();
+          result = BROTLI_FAILURE_REASON(s, "reserved window size");
+          break;
+        }
+        if (s->max_window_bits != 0 && s->window_bits > s->max_window_bits) {
+          /* Refuse to allocate a larger ringbuffer than allowed. */
+          result = BROTLI_FAILURE_REASON(s, "window size exceeds limit");
           break;
         }
         s->max_backward_distance = (1 << s->window_bits) - 16;
@@ -1982,7 +1995,7 @@ BrotliResult BrotliDecompressStream(size_t* available_in,
             sizeof(HuffmanCode) * 3 *
                 (BROTLI_HUFFMAN_MAX_SIZE_258 + BROTLI_HUFFMAN_MAX_SIZE_26));
         if (s->block_type_trees == 0) {
-          result = BROTLI_FAILURE();
+          result = BROTLI_FAILURE_REASON(s, "out of memory");
           break;
         }
         s->block_len_trees = s->block_type_trees +
@@ -1992,6 +2005,7 @@ BrotliResult BrotliDecompressStream(size_t* available_in,
         /* No break, continue to next state */
       case BROTLI_STATE_METABLOCK_BEGIN:
         BrotliStateMetablockBegin(s);
+        s->metablock_count++;
         BROTLI_LOG_UINT(s->pos);
         s->state = BROTLI_STATE_METABLOCK_HEADER;
         /* No break, continue to next state */
@@ -2006,7 +2020,7 @@ BrotliResult BrotliDecompressStream(size_t* available_in,
         BROTLI_LOG_UINT(s->is_uncompressed);
         if (s->is_metadata || s->is_uncompressed) {
           if (!BrotliJumpToByteBoundary(br)) {
-            result = BROTLI_FAILURE();
+            result = BROTLI_FAILURE_REASON(s, "non-zero padding bits");
             break;
           }
         }
@@ -2114,7 +2128,7 @@ BrotliResult BrotliDecompressStream(size_t* available_in,
         s->context_modes =
             (uint8_t*)BROTLI_ALLOC(s, (size_t)s->num_block_types[0]);
         if (s->context_modes == 0) {
-          result = BROTLI_FAILURE();
+          result = BROTLI_FAILURE_REASON(s, "out of memory");
           break;
         }
         s->loop_counter = 0;
@@ -2165,7 +2179,7 @@ BrotliResult BrotliDecompressStream(size_t* available_in,
           if (s->literal_hgroup.codes == 0 ||
               s->insert_copy_hgroup.codes == 0 ||
               s->distance_hgroup.codes == 0) {
-            return BROTLI_FAILURE();
+            return BROTLI_FAILURE_REASON(s, "out of memory");
           }
         }
         s->loop_counter = 0;
@@ -2200,7 +2214,7 @@ BrotliResult BrotliDecompressStream(size_t* available_in,
           s->htree_command = s->insert_copy_hgroup.htrees[0];
           s->literal_htree = s->literal_hgroup.htrees[s->literal_htree_index];
           if (!s->ringbuffer && !BrotliAllocateRingBuffer(s)) {
-            result = BROTLI_FAILURE();
+            result = BROTLI_FAILURE_REASON(s, "out of memory");
             break;
           }
           s->state = BROTLI_STATE_COMMAND_BEGIN;
@@ -2255,7 +2269,7 @@ BrotliResult BrotliDecompressStream(size_t* available_in,
           break;
         }
         if (!BrotliJumpToByteBoundary(br)) {
-          result = BROTLI_FAILURE();
+          result = BROTLI_FAILURE_REASON(s, "non-zero padding bits at end of stream");
         }
         if (s->buffer_length == 0) {
           BrotliBitReaderUnload(br);
@@ -2283,6 +2297,10 @@ void BrotliSetCustomDictionary(
   s->custom_dict_size = (int) size;
 }
 
+void BrotliSetMaxWindowBits(uint32_t window_bits, BrotliState* s) {
+  s->max_window_bits = window_bits;
+}
+
 
 #if defined(__cplusplus) || defined(c_plusplus)
 }    /* extern "C" */
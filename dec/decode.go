@@ -2,6 +2,10 @@
 package dec // import "gopkg.in/kothar/brotli-go.v0/dec"
 
 /*
+// for malloc, free and memcpy
+#include <stdlib.h>
+#include <string.h>
+
 #include "./decode.h"
 
 typedef uint8_t dict[122784];
@@ -29,6 +33,7 @@ import "C"
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"runtime"
 	"unsafe"
@@ -41,99 +46,235 @@ func init() {
 	C.decodeBrotliDictionary = (*C.dict)(shared.GetDictionary())
 }
 
+// Errors which may be returned when decoding
+var (
+	// ErrCorrupt is the cause of a DecodeError for invalid compressed data.
+	ErrCorrupt = errors.New("Brotli decompression error: corrupt input")
+
+	// ErrTruncated is the cause of a DecodeError for compressed data which
+	// ends before the end of the stream. It is the same as io.ErrUnexpectedEOF.
+	ErrTruncated = io.ErrUnexpectedEOF
+
+	// ErrDecoderClosed is returned when a Decoder or BrotliReader is used
+	// after it has been closed.
+	ErrDecoderClosed = errors.New("Brotli decoder is closed")
+
+	// ErrOutputLimitExceeded is returned when the decompressed output would be
+	// larger than the limit set with DecompressBufferLimit or SetMaxOutput.
+	ErrOutputLimitExceeded = errors.New("Brotli decompression error: output limit exceeded")
+
+	// ErrWindowSizeExceeded is returned when a stream declares a larger sliding
+	// window than the limit set with SetMaxWindowBits.
+	ErrWindowSizeExceeded = errors.New("Brotli decompression error: window size exceeds limit")
+)
+
+// DecodeError describes where decoding failed for invalid or truncated
+// compressed data.
+type DecodeError struct {
+	Err       error  // ErrCorrupt or ErrTruncated
+	Offset    int64  // Number of compressed bytes consumed before the failure
+	MetaBlock int    // Index of the meta-block being decoded, counting from 0
+	Reason    string // What the decoder found to be invalid, if known
+}
+
+func (e *DecodeError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("%v at offset %d (meta-block %d)", e.Err, e.Offset, e.MetaBlock)
+	}
+	return fmt.Sprintf("%v: %s at offset %d (meta-block %d)", e.Err, e.Reason, e.Offset, e.MetaBlock)
+}
+
+// Unwrap returns the cause of the error, ErrCorrupt or ErrTruncated.
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
 // DecompressBuffer decompress a Brotli-encoded buffer. Uses decodedBuffer as the destination buffer unless it is too small,
 // in which case a new buffer is allocated.
 // Returns the slice of the decodedBuffer containing the output, or an error.
 func DecompressBuffer(encodedBuffer []byte, decodedBuffer []byte) ([]byte, error) {
-	encodedLength := len(encodedBuffer)
-	var decodedSize C.size_t
-
-	// If the user has provided a sensibly size buffer, assume they know how long the output should be
-	// Otherwise try to determine the correct length from the input
-	if len(decodedBuffer) < len(encodedBuffer) {
-		success := C.BrotliDecompressedSize(C.size_t(encodedLength), toC(encodedBuffer), &decodedSize)
-		if success != 1 {
-			// We can't know in advance how much buffer to allocate, so we will just have to guess
-			decodedSize = C.size_t(len(encodedBuffer) * 6)
-		}
+	return DecompressBufferLimit(encodedBuffer, decodedBuffer, 0)
+}
 
-		if len(decodedBuffer) < int(decodedSize) {
-			decodedBuffer = make([]byte, decodedSize)
-		}
-	}
+// DecompressBufferLimit is the same as DecompressBuffer, but fails with
+// ErrOutputLimitExceeded as soon as the decompressed output would be larger
+// than maxOutput bytes. Buffers are never grown beyond the limit, so this is
+// safe to use on untrusted input. A limit of 0 means no limit.
+func DecompressBufferLimit(encodedBuffer []byte, decodedBuffer []byte, maxOutput int) ([]byte, error) {
+	state := C.BrotliCreateState(nil, nil, nil)
+	defer C.BrotliDestroyState(state)
 
-	// The size of the ouput buffer available
-	decodedLength := C.size_t(len(decodedBuffer))
-	result := C.BrotliDecompressBuffer(C.size_t(encodedLength), toC(encodedBuffer), &decodedLength, toC(decodedBuffer))
-	switch result {
-	case C.BROTLI_RESULT_SUCCESS:
-		// We're finished
-		return decodedBuffer[0:decodedLength], nil
-	case C.BROTLI_RESULT_NEEDS_MORE_OUTPUT:
-		// We needed more output buffer
-		decodedBuffer = make([]byte, len(decodedBuffer)*2)
-		return DecompressBuffer(encodedBuffer, decodedBuffer)
-	case C.BROTLI_RESULT_ERROR:
-		return nil, errors.New("Brotli decompression error")
-	case C.BROTLI_RESULT_NEEDS_MORE_INPUT:
-		// We can't handle streaming more input results here
-		return nil, errors.New("Brotli decompression error: needs more input")
-	default:
-		return nil, errors.New("Unrecognised Brotli decompression error")
-	}
+	return decompressStream(state, encodedBuffer, decodedBuffer, maxOutput, nil)
 }
 
 // DecompressBufferDict decompress a Brotli-encoded buffer. Uses decodedBuffer as the destination buffer unless it is too small,
 // in which case a new buffer is allocated.
 // Returns the slice of the decodedBuffer containing the output, or an error.
 func DecompressBufferDict(encodedBuffer []byte, inputDict []byte, decodedBuffer []byte) ([]byte, error) {
-	encodedLength := len(encodedBuffer)
-	dictLength := len(inputDict)
-	var decodedSize C.size_t
+	state := C.BrotliCreateState(nil, nil, nil)
+	defer C.BrotliDestroyState(state)
+
+	// The state refers to the dictionary between calls, so it must be in C memory
+	if len(inputDict) > 0 {
+		dictSize := C.size_t(len(inputDict))
+		cDict := C.malloc(dictSize)
+		defer C.free(cDict)
+		C.memcpy(cDict, unsafe.Pointer(&inputDict[0]), dictSize)
+		C.BrotliSetCustomDictionary(dictSize, (*C.uint8_t)(cDict), state)
+	}
+
+	return decompressStream(state, encodedBuffer, decodedBuffer, 0, nil)
+}
+
+// The largest amount of input or output processed in one go when
+// decompression can be cancelled
+const cancelSliceSize = 1 << 20
+
+// Decompresses a complete Brotli stream using a freshly initialised state,
+// growing decodedBuffer as needed up to maxOutput bytes (0 for no limit).
+// If cancelled is not nil, the data is decompressed in slices of at most
+// cancelSliceSize bytes, and cancelled is called before each slice.
+func decompressStream(state *C.BrotliState, encodedBuffer []byte, decodedBuffer []byte, maxOutput int, cancelled func() error) ([]byte, error) {
+	// Leave room for one byte over the limit, to tell if the limit was exceeded
+	limit := func(size int) int {
+		if maxOutput > 0 && size > maxOutput+1 {
+			return maxOutput + 1
+		}
+		return size
+	}
 
 	// If the user has provided a sensibly size buffer, assume they know how long the output should be
 	// Otherwise try to determine the correct length from the input
+	decodedBuffer = decodedBuffer[:cap(decodedBuffer)]
 	if len(decodedBuffer) < len(encodedBuffer) {
-		success := C.BrotliDecompressedSize(C.size_t(encodedLength), toC(encodedBuffer), &decodedSize)
+		var decodedSize C.size_t
+		success := C.BrotliDecompressedSize(C.size_t(len(encodedBuffer)), toC(encodedBuffer), &decodedSize)
 		if success != 1 {
 			// We can't know in advance how much buffer to allocate, so we will just have to guess
 			decodedSize = C.size_t(len(encodedBuffer) * 6)
 		}
 
-		if len(decodedBuffer) < int(decodedSize) {
-			decodedBuffer = make([]byte, decodedSize)
+		if size := limit(int(decodedSize)); len(decodedBuffer) < size {
+			decodedBuffer = make([]byte, size)
 		}
 	}
+	if len(decodedBuffer) == 0 {
+		// The output may well be empty, but the decoder needs somewhere to write
+		decodedBuffer = make([]byte, 1)
+	}
 
-	// The size of the ouput buffer available
-	decodedLength := C.size_t(len(decodedBuffer))
-
-	result := C.BrotliDecompressBufferDict(
-		C.size_t(encodedLength), toC(encodedBuffer),
-		C.size_t(dictLength), toC(inputDict),
-		&decodedLength, toC(decodedBuffer))
-	switch result {
-	case C.BROTLI_RESULT_SUCCESS:
-		// We're finished
-		return decodedBuffer[0:decodedLength], nil
-	case C.BROTLI_RESULT_NEEDS_MORE_OUTPUT:
-		// We needed more output buffer
-		decodedBuffer = make([]byte, len(decodedBuffer)*2)
-		return DecompressBufferDict(encodedBuffer, inputDict, decodedBuffer)
-	case C.BROTLI_RESULT_ERROR:
-		return nil, errors.New("Brotli decompression error")
-	case C.BROTLI_RESULT_NEEDS_MORE_INPUT:
-		// We can't handle streaming more input results here
-		return nil, errors.New("Brotli decompression error: needs more input")
-	default:
-		return nil, errors.New("Unrecognised Brotli decompression error")
+	inputPosition := 0
+	var totalOut C.size_t
+	for {
+		if cancelled != nil {
+			if err := cancelled(); err != nil {
+				return nil, err
+			}
+		}
+
+		// Make sure there is room for more output
+		if int(totalOut) == len(decodedBuffer) {
+			grown := make([]byte, limit(len(decodedBuffer)*2))
+			copy(grown, decodedBuffer)
+			decodedBuffer = grown
+		}
+
+		inputSize := len(encodedBuffer) - inputPosition
+		outputSize := limit(len(decodedBuffer)) - int(totalOut)
+		if cancelled != nil {
+			// Keep each call to the decoder short
+			if inputSize > cancelSliceSize {
+				inputSize = cancelSliceSize
+			}
+			if outputSize > cancelSliceSize {
+				outputSize = cancelSliceSize
+			}
+		}
+
+		nextIn := unsafe.Pointer(nil)
+		if inputSize > 0 {
+			nextIn = unsafe.Pointer(&encodedBuffer[inputPosition])
+		}
+		availableIn := C.size_t(inputSize)
+		availableOut := C.size_t(outputSize)
+		result := C.BrotliDecompressStream_Wrapper(
+			&availableIn,
+			(*C.uint8_t)(nextIn),
+			&availableOut,
+			toC(decodedBuffer[totalOut:]),
+			&totalOut,
+			state,
+		)
+		inputPosition += inputSize - int(availableIn)
+
+		if maxOutput > 0 && int(totalOut) > maxOutput {
+			return nil, ErrOutputLimitExceeded
+		}
+
+		switch result {
+		case C.BROTLI_RESULT_SUCCESS:
+			// We're finished
+			return decodedBuffer[0:totalOut], nil
+		case C.BROTLI_RESULT_NEEDS_MORE_OUTPUT:
+			// Continue with a larger output buffer
+		case C.BROTLI_RESULT_ERROR:
+			return nil, decompressionError(state, int64(inputPosition))
+		case C.BROTLI_RESULT_NEEDS_MORE_INPUT:
+			if inputPosition < len(encodedBuffer) {
+				// Continue with the next slice of input
+				continue
+			}
+			return nil, truncationError(state, int64(len(encodedBuffer)))
+		default:
+			return nil, errors.New("Unrecognised Brotli decompression error")
+		}
+	}
+}
+
+// Describes why the decoder failed after consuming offset bytes of input
+func decompressionError(state *C.BrotliState, offset int64) error {
+	if state.max_window_bits != 0 && state.window_bits > state.max_window_bits {
+		return ErrWindowSizeExceeded
+	}
+	return &DecodeError{
+		Err:       ErrCorrupt,
+		Offset:    offset,
+		MetaBlock: metaBlockIndex(state),
+		Reason:    failureReason(state),
+	}
+}
+
+// Describes where the input ended, after offset bytes, before the end of the stream
+func truncationError(state *C.BrotliState, offset int64) error {
+	return &DecodeError{
+		Err:       ErrTruncated,
+		Offset:    offset,
+		MetaBlock: metaBlockIndex(state),
+	}
+}
+
+func metaBlockIndex(state *C.BrotliState) int {
+	if state.metablock_count == 0 {
+		return 0
 	}
+	return int(state.metablock_count) - 1
+}
+
+// The reason recorded by the decoder where it failed, if any
+func failureReason(state *C.BrotliState) string {
+	if state.error_reason == nil {
+		return ""
+	}
+	return C.GoString(state.error_reason)
 }
 
 func toC(array []byte) *C.uint8_t {
 	return (*C.uint8_t)(unsafe.Pointer(&array[0]))
 }
 
+// The size of the buffer used by WriteTo for decompressed data
+const writeToBufferSize = 256 * 1024
+
 // BrotliReader decompresses a Brotli-encoded stream using the io.Reader interface
 type BrotliReader struct {
 	reader io.Reader
@@ -142,14 +283,27 @@ type BrotliReader struct {
 	// C-allocated state. Must be cleaned up by calling Close() or a memory leak will occur
 	state unsafe.Pointer
 
+	// Custom dictionary, and a C-allocated copy which must stay in place while
+	// the state refers to it
+	dict  []byte
+	cDict unsafe.Pointer
+
 	needOutput bool  // State bounces between needing input and output
 	err        error // Persistent error
 
 	buffer     []byte // Internal buffer for compressed data
 	bufferRead int    // How many bytes in the buffer are valid
 
+	writeBuffer []byte // Internal buffer for decompressed data used by WriteTo
+
 	availableIn C.size_t
+	totalIn     int64 // How many bytes of compressed data have been consumed
 	totalOut    C.size_t
+
+	maxOutput     int64 // Limit on the decompressed size, or 0
+	maxWindowBits int   // Limit on the window size declared by the stream, or 0
+
+	cancelled func() error // Returns an error once the stream has been cancelled, if not nil
 }
 
 // Fill a buffer, p, with the decompressed contents of the stream.
@@ -158,9 +312,18 @@ func (r *BrotliReader) Read(p []byte) (n int, err error) {
 	if len(p) == 0 || r.err != nil {
 		return 0, r.err
 	}
+	if r.cancelled != nil {
+		if err := r.cancelled(); err != nil {
+			return 0, err
+		}
+	}
 
 	// Prepare arguments
 	maxOutput := len(p)
+	if r.maxOutput > 0 && int64(maxOutput) > r.maxOutput-int64(r.totalOut) {
+		// Leave room for one byte over the limit, to tell if the limit was exceeded
+		maxOutput = int(r.maxOutput-int64(r.totalOut)) + 1
+	}
 	availableOut := C.size_t(maxOutput)
 
 	if r.err == nil {
@@ -172,7 +335,7 @@ func (r *BrotliReader) Read(p []byte) (n int, err error) {
 			}
 			if err != nil {
 				if err == io.EOF {
-					err = io.ErrUnexpectedEOF
+					err = truncationError((*C.BrotliState)(r.state), r.totalIn)
 				}
 				r.err = err
 			}
@@ -187,6 +350,7 @@ func (r *BrotliReader) Read(p []byte) (n int, err error) {
 			if r.availableIn > 0 {
 				nextIn = unsafe.Pointer(&r.buffer[inputPosition])
 			}
+			availableIn := r.availableIn
 			result := C.BrotliDecompressStream_Wrapper(
 				&r.availableIn,
 				(*C.uint8_t)(nextIn),
@@ -195,9 +359,17 @@ func (r *BrotliReader) Read(p []byte) (n int, err error) {
 				&r.totalOut,
 				(*C.BrotliState)(r.state),
 			)
+			r.totalIn += int64(availableIn - r.availableIn)
 
 			n = maxOutput - int(availableOut)
 
+			if r.maxOutput > 0 && int64(r.totalOut) > r.maxOutput {
+				// Only return the output up to the limit
+				n -= int(int64(r.totalOut) - r.maxOutput)
+				r.err = ErrOutputLimitExceeded
+				return n, r.err
+			}
+
 			switch result {
 			case C.BROTLI_RESULT_SUCCESS:
 				r.err = io.EOF
@@ -206,11 +378,13 @@ func (r *BrotliReader) Read(p []byte) (n int, err error) {
 				if n > 0 {
 					return n, r.err
 				}
-				r.err = errors.New("Brotli decompression error: needs more output buffer")
+				r.err = io.ErrShortBuffer
 			case C.BROTLI_RESULT_ERROR:
-				r.err = errors.New("Brotli decompression error")
+				r.err = decompressionError((*C.BrotliState)(r.state), r.totalIn)
 			case C.BROTLI_RESULT_NEEDS_MORE_INPUT:
-				r.needOutput = false
+				// If the output buffer was filled, the decoder may still be holding
+				// output for the input it has already consumed
+				r.needOutput = availableOut == 0
 			default:
 				r.err = errors.New("Unrecognized Brotli decompression error")
 			}
@@ -223,6 +397,39 @@ func (r *BrotliReader) Read(p []byte) (n int, err error) {
 	return n, r.err
 }
 
+// WriteTo implements the io.WriterTo interface, so that io.Copy decompresses
+// the rest of the stream directly to dst. Output is decompressed into an
+// internal 256kb buffer, so that there are fewer calls to the decoder than
+// with a small buffer passed to Read.
+// Returns the number of bytes written to dst, or an error.
+func (r *BrotliReader) WriteTo(dst io.Writer) (int64, error) {
+	if r.writeBuffer == nil {
+		r.writeBuffer = make([]byte, writeToBufferSize)
+	}
+
+	var total int64
+	for {
+		n, err := r.Read(r.writeBuffer)
+		if n > 0 {
+			written, werr := dst.Write(r.writeBuffer[:n])
+			total += int64(written)
+			if werr == nil && written < n {
+				werr = io.ErrShortWrite
+			}
+			if werr != nil {
+				return total, werr
+			}
+		}
+
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
 // Close the reader and clean up any decompressor state.
 func (r *BrotliReader) Close() error {
 	if r.closed {
@@ -230,14 +437,44 @@ func (r *BrotliReader) Close() error {
 	}
 	C.BrotliStateCleanup((*C.BrotliState)(r.state))
 	C.BrotliDestroyState((*C.BrotliState)(r.state))
+	if r.cDict != nil {
+		C.free(r.cDict)
+		r.cDict = nil
+	}
 	r.closed = true
 	if r.err == nil || r.err == io.EOF {
-		r.err = io.ErrClosedPipe // Make sure future operations fail
+		r.err = ErrDecoderClosed // Make sure future operations fail
 		return nil
 	}
 	return r.err
 }
 
+// Reset discards the state of the BrotliReader and makes it equivalent to the
+// result of NewBrotliReader, reading from stream instead. The internal buffer
+// and the native decoder state are reused, unless the state has already been
+// released by Close. A custom dictionary passed to NewBrotliReaderDict is kept,
+// as are the limits set with SetMaxOutput and SetMaxWindowBits, and the context
+// passed to NewBrotliReaderContext.
+func (r *BrotliReader) Reset(stream io.Reader) {
+	if r.closed {
+		r.state = unsafe.Pointer(C.BrotliCreateState(nil, nil, nil))
+		r.closed = false
+	} else {
+		C.BrotliStateCleanup((*C.BrotliState)(r.state))
+	}
+	C.BrotliStateInit((*C.BrotliState)(r.state))
+	r.setCustomDictionary()
+	C.BrotliSetMaxWindowBits(C.uint32_t(r.maxWindowBits), (*C.BrotliState)(r.state))
+
+	r.reader = stream
+	r.needOutput = false
+	r.err = nil
+	r.bufferRead = 0
+	r.availableIn = 0
+	r.totalIn = 0
+	r.totalOut = 0
+}
+
 // NewBrotliReader returns a Reader that decompresses the stream from another reader.
 //
 // Ensure that you Close the stream when you are finished in order to clean up the
@@ -265,3 +502,120 @@ func NewBrotliReaderSize(stream io.Reader, size int) *BrotliReader {
 
 	return r
 }
+
+// NewBrotliReaderDict returns a Reader that decompresses the stream from another reader
+// using a custom dictionary. The stream must have been compressed with the same dictionary.
+//
+// The dictionary is copied, so it may be modified once the reader has been created.
+func NewBrotliReaderDict(stream io.Reader, dict []byte) *BrotliReader {
+	r := NewBrotliReader(stream)
+	r.dict = append([]byte(nil), dict...)
+	r.setCustomDictionary()
+
+	return r
+}
+
+// SetMaxOutput limits the size of the decompressed stream. Once maxOutput bytes
+// have been read, Read fails with ErrOutputLimitExceeded if the stream
+// contains any more data. A limit of 0 means no limit.
+func (r *BrotliReader) SetMaxOutput(maxOutput int64) {
+	r.maxOutput = maxOutput
+}
+
+// SetMaxWindowBits makes Read fail with ErrWindowSizeExceeded for streams with
+// a sliding window larger than (1 << bits) bytes, which bounds the memory used
+// by the decoder. It must be called before the first Read. A limit of 0 means
+// no limit.
+func (r *BrotliReader) SetMaxWindowBits(bits int) {
+	r.maxWindowBits = bits
+	if !r.closed {
+		C.BrotliSetMaxWindowBits(C.uint32_t(bits), (*C.BrotliState)(r.state))
+	}
+}
+
+// InputOffset returns the number of bytes of compressed data the decoder has
+// consumed. It can be less than the number read from the underlying Reader,
+// which is read a buffer at a time.
+func (r *BrotliReader) InputOffset() int64 {
+	return r.totalIn
+}
+
+// Pass the custom dictionary, if any, to a newly initialised decoder state
+func (r *BrotliReader) setCustomDictionary() {
+	if len(r.dict) == 0 {
+		return
+	}
+
+	dictSize := C.size_t(len(r.dict))
+	if r.cDict == nil {
+		r.cDict = C.malloc(dictSize)
+		C.memcpy(r.cDict, unsafe.Pointer(&r.dict[0]), dictSize)
+	}
+	C.BrotliSetCustomDictionary(dictSize, (*C.uint8_t)(r.cDict), (*C.BrotliState)(r.state))
+}
+
+// Decoder decompresses single blocks of data like DecompressBuffer, but keeps
+// its native decoder state between calls. A Decoder must not be used from
+// multiple goroutines at the same time, but it may be kept in a sync.Pool and
+// reused.
+//
+// Ensure that you Close the Decoder when you are finished with it in order to
+// release the native decoder state.
+type Decoder struct {
+	// C-allocated state. Must be cleaned up by calling Close() or a memory leak will occur
+	state unsafe.Pointer
+
+	maxOutput     int // Limit on the decompressed size, or 0
+	maxWindowBits int // Limit on the window size declared by streams, or 0
+}
+
+// NewDecoder instantiates a Decoder
+func NewDecoder() *Decoder {
+	d := &Decoder{
+		state: unsafe.Pointer(C.BrotliCreateState(nil, nil, nil)),
+	}
+
+	runtime.SetFinalizer(d, func(c io.Closer) { c.Close() })
+
+	return d
+}
+
+// DecompressBuffer decompress a Brotli-encoded buffer. Uses decodedBuffer as the destination buffer unless it is too small,
+// in which case a new buffer is allocated.
+// Returns the slice of the decodedBuffer containing the output, or an error.
+func (d *Decoder) DecompressBuffer(encodedBuffer []byte, decodedBuffer []byte) ([]byte, error) {
+	if d.state == nil {
+		return nil, ErrDecoderClosed
+	}
+	state := (*C.BrotliState)(d.state)
+	C.BrotliStateCleanup(state)
+	C.BrotliStateInit(state)
+	C.BrotliSetMaxWindowBits(C.uint32_t(d.maxWindowBits), state)
+
+	return decompressStream(state, encodedBuffer, decodedBuffer, d.maxOutput, nil)
+}
+
+// SetMaxOutput limits the size of the output of DecompressBuffer, which fails
+// with ErrOutputLimitExceeded if the output would be larger than maxOutput
+// bytes. A limit of 0 means no limit.
+func (d *Decoder) SetMaxOutput(maxOutput int) {
+	d.maxOutput = maxOutput
+}
+
+// SetMaxWindowBits makes DecompressBuffer fail with ErrWindowSizeExceeded for
+// streams with a sliding window larger than (1 << bits) bytes, which bounds
+// the memory used by the decoder. A limit of 0 means no limit.
+func (d *Decoder) SetMaxWindowBits(bits int) {
+	d.maxWindowBits = bits
+}
+
+// Close releases the native decoder state used by the Decoder.
+func (d *Decoder) Close() error {
+	if d.state == nil {
+		return nil
+	}
+	C.BrotliStateCleanup((*C.BrotliState)(d.state))
+	C.BrotliDestroyState((*C.BrotliState)(d.state))
+	d.state = nil
+	return nil
+}
@@ -97,6 +97,12 @@ BrotliResult BrotliDecompressStream(size_t* available_in,
 void BrotliSetCustomDictionary(
     size_t size, const uint8_t* dict, BrotliState* s);
 
+/* Limits the window size of streams accepted by the decoder, which bounds the
+   size of the ringbuffer it allocates. Streams declaring a window larger than
+   (1 << window_bits) bytes fail to decode. 0 means no limit.
+   Must be called after BrotliStateInit, before decoding starts. */
+void BrotliSetMaxWindowBits(uint32_t window_bits, BrotliState* s);
+
 
 #if defined(__cplusplus) || defined(c_plusplus)
 } /* extern "C" */
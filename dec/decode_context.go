@@ -0,0 +1,45 @@
+//go:build go1.7
+// +build go1.7
+
+package dec
+
+/*
+#include "./decode.h"
+*/
+import "C"
+
+import (
+	"context"
+	"io"
+)
+
+// DecompressBufferContext decompresses a Brotli-encoded buffer like
+// DecompressBuffer, but returns ctx.Err() as soon as ctx is cancelled. The
+// data is decompressed in slices of at most 1mb of input or output, and the
+// context is checked before each slice.
+//
+// It uses decodedBuffer as the destination buffer unless it is too small, in
+// which case a new buffer is allocated. No output is returned if ctx is
+// cancelled, though decodedBuffer may have been written to.
+func DecompressBufferContext(ctx context.Context, encodedBuffer []byte, decodedBuffer []byte) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	state := C.BrotliCreateState(nil, nil, nil)
+	defer C.BrotliDestroyState(state)
+
+	return decompressStream(state, encodedBuffer, decodedBuffer, 0, ctx.Err)
+}
+
+// NewBrotliReaderContext returns a Reader like NewBrotliReader, which returns
+// ctx.Err() from Read once ctx is cancelled. Each Read decompresses at most
+// one buffer of input, so cancellation is noticed by the next Read.
+//
+// Ensure that you Close the stream when you are finished in order to clean up the
+// Brotli decompression state.
+func NewBrotliReaderContext(ctx context.Context, stream io.Reader) *BrotliReader {
+	r := NewBrotliReader(stream)
+	r.cancelled = ctx.Err
+	return r
+}
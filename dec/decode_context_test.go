@@ -0,0 +1,87 @@
+//go:build go1.7
+// +build go1.7
+
+package dec
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"gopkg.in/kothar/brotli-go.v0/enc"
+)
+
+// Cancels its context after being called a number of times
+type cancelAfter struct {
+	context.Context
+	cancel func()
+	calls  int
+}
+
+func (c *cancelAfter) Err() error {
+	c.calls--
+	if c.calls == 0 {
+		c.cancel()
+	}
+	return c.Context.Err()
+}
+
+func newCancelAfter(calls int) *cancelAfter {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &cancelAfter{ctx, cancel, calls}
+}
+
+func TestDecompressBufferContext(T *testing.T) {
+	input1 := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 100000)
+
+	params := enc.NewBrotliParams()
+	params.SetQuality(4)
+	encoded, err := enc.CompressBuffer(params, input1, nil)
+	if err != nil {
+		T.Fatal(err)
+	}
+
+	decoded, err := DecompressBufferContext(context.Background(), encoded, nil)
+	if err != nil {
+		T.Fatal(err)
+	}
+	if !bytes.Equal(decoded, input1) {
+		T.Error("Decoded output does not match original input")
+	}
+
+	// Cancel part-way through, after the first slice of output
+	ctx := newCancelAfter(3)
+	decoded, err = DecompressBufferContext(ctx, encoded, nil)
+	if err != context.Canceled || decoded != nil {
+		T.Errorf("Expected context.Canceled and no output, got %v and %d bytes", err, len(decoded))
+	}
+	if ctx.calls != 0 {
+		T.Errorf("Expected decompression to stop as soon as it was cancelled")
+	}
+}
+
+func TestReaderContext(T *testing.T) {
+	input1 := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 100000)
+
+	encoded, err := enc.CompressBuffer(nil, input1, nil)
+	if err != nil {
+		T.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	reader := NewBrotliReaderContext(ctx, bytes.NewReader(encoded))
+	defer reader.Close()
+
+	buffer := make([]byte, 1000)
+	if _, err := reader.Read(buffer); err != nil {
+		T.Fatal(err)
+	}
+	cancel()
+	if n, err := reader.Read(buffer); n != 0 || err != context.Canceled {
+		T.Errorf("Expected context.Canceled and no output, got %v and %d bytes", err, n)
+	}
+	if _, err := ioutil.ReadAll(reader); err != context.Canceled {
+		T.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
@@ -3,6 +3,7 @@ package dec
 import (
 	"bytes"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -138,3 +139,268 @@ func TestEOFBehavior(T *testing.T) {
 		T.Fatalf("Expected to read %d bytes, read %d", len(input), readBytes)
 	}
 }
+
+func TestDecoderReuse(T *testing.T) {
+	inputs := [][]byte{
+		bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 100000),
+		[]byte("Hello Hello Hello, Hello Hello Hello"),
+	}
+
+	params := enc.NewBrotliParams()
+	params.SetQuality(4)
+
+	decoder := NewDecoder()
+	defer decoder.Close()
+
+	for _, input := range inputs {
+		compressed, err := enc.CompressBuffer(params, input, nil)
+		if err != nil {
+			T.Fatal(err)
+		}
+
+		// Start with a small output buffer to test growing it
+		for _, decoded := range [][]byte{nil, make([]byte, 10), make([]byte, len(input))} {
+			decoded, err = decoder.DecompressBuffer(compressed, decoded)
+			if err != nil {
+				T.Fatal(err)
+			}
+			if !bytes.Equal(decoded, input) {
+				T.Error("Decoded output does not match original input")
+			}
+		}
+	}
+
+	if _, err := decoder.DecompressBuffer([]byte{1, 2, 3, 4}, nil); err == nil {
+		T.Error("Expected an error for corrupt input")
+	}
+}
+
+func TestReaderReset(T *testing.T) {
+	input1 := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 100000)
+	input2 := []byte("Hello Hello Hello, Hello Hello Hello")
+
+	params := enc.NewBrotliParams()
+	params.SetQuality(4)
+
+	compressed1, err := enc.CompressBuffer(params, input1, nil)
+	if err != nil {
+		T.Fatal(err)
+	}
+	compressed2, err := enc.CompressBuffer(params, input2, nil)
+	if err != nil {
+		T.Fatal(err)
+	}
+
+	// Abandon the first stream part way through
+	reader := NewBrotliReader(bytes.NewReader(compressed1))
+	if _, err := reader.Read(make([]byte, 500)); err != nil {
+		T.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		reader.Reset(bytes.NewReader(compressed2))
+		decoded, err := ioutil.ReadAll(reader)
+		if err != nil {
+			T.Fatal(err)
+		}
+		if !bytes.Equal(decoded, input2) {
+			T.Error("Decoded output does not match original input")
+		}
+
+		// The second round reuses a closed reader
+		reader.Close()
+	}
+}
+
+func TestOutputLimit(T *testing.T) {
+	input1 := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 100000)
+
+	params := enc.NewBrotliParams()
+	params.SetQuality(4)
+	encoded, err := enc.CompressBuffer(params, input1, nil)
+	if err != nil {
+		T.Fatal(err)
+	}
+
+	// Buffer API
+	if _, err := DecompressBufferLimit(encoded, nil, len(input1)-1); err != ErrOutputLimitExceeded {
+		T.Errorf("Expected ErrOutputLimitExceeded, got %v", err)
+	}
+	decoded, err := DecompressBufferLimit(encoded, nil, len(input1))
+	if err != nil {
+		T.Fatal(err)
+	}
+	if !bytes.Equal(decoded, input1) {
+		T.Error("Decoded output does not match original input")
+	}
+
+	decoder := NewDecoder()
+	defer decoder.Close()
+	decoder.SetMaxOutput(1000)
+	if _, err := decoder.DecompressBuffer(encoded, nil); err != ErrOutputLimitExceeded {
+		T.Errorf("Expected ErrOutputLimitExceeded, got %v", err)
+	}
+
+	// Stream API
+	reader := NewBrotliReader(bytes.NewReader(encoded))
+	reader.SetMaxOutput(int64(len(input1) - 1))
+	decoded, err = ioutil.ReadAll(reader)
+	if err != ErrOutputLimitExceeded {
+		T.Errorf("Expected ErrOutputLimitExceeded, got %v", err)
+	}
+	if !bytes.Equal(decoded, input1[:len(input1)-1]) {
+		T.Errorf("Expected output up to the limit, got %d bytes", len(decoded))
+	}
+
+	reader.Reset(bytes.NewReader(encoded))
+	reader.SetMaxOutput(int64(len(input1)))
+	decoded, err = ioutil.ReadAll(reader)
+	if err != nil {
+		T.Fatal(err)
+	}
+	if !bytes.Equal(decoded, input1) {
+		T.Error("Decoded output does not match original input")
+	}
+	reader.Close()
+}
+
+func TestInputOffset(T *testing.T) {
+	input := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 100000)
+	params := enc.NewBrotliParams()
+	params.SetQuality(4)
+	encoded, err := enc.CompressBuffer(params, input, nil)
+	if err != nil {
+		T.Fatal(err)
+	}
+
+	// Data after the end of the stream is read but not consumed
+	source := bytes.NewReader(append(append([]byte(nil), encoded...), make([]byte, 1000)...))
+	reader := NewBrotliReader(source)
+	defer reader.Close()
+	first := make([]byte, 100)
+	if _, err := io.ReadFull(reader, first); err != nil {
+		T.Fatal(err)
+	}
+	if offset := reader.InputOffset(); offset <= 0 || offset >= int64(len(encoded)) {
+		T.Errorf("Expected part of the stream to be consumed, got offset %d of %d", offset, len(encoded))
+	}
+
+	if _, err := ioutil.ReadAll(reader); err != nil {
+		T.Fatal(err)
+	}
+	if offset := reader.InputOffset(); offset != int64(len(encoded)) {
+		T.Errorf("Expected offset %d at the end of the stream, got %d", len(encoded), offset)
+	}
+}
+
+func TestMaxWindowBits(T *testing.T) {
+	input1 := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 1000)
+
+	params := enc.NewBrotliParams()
+	params.SetLgwin(20)
+	encoded, err := enc.CompressBuffer(params, input1, nil)
+	if err != nil {
+		T.Fatal(err)
+	}
+
+	decoder := NewDecoder()
+	defer decoder.Close()
+	decoder.SetMaxWindowBits(16)
+	if _, err := decoder.DecompressBuffer(encoded, nil); err != ErrWindowSizeExceeded {
+		T.Errorf("Expected ErrWindowSizeExceeded, got %v", err)
+	}
+	decoder.SetMaxWindowBits(20)
+	if _, err := decoder.DecompressBuffer(encoded, nil); err != nil {
+		T.Error(err)
+	}
+
+	reader := NewBrotliReader(bytes.NewReader(encoded))
+	defer reader.Close()
+	reader.SetMaxWindowBits(16)
+	if _, err := ioutil.ReadAll(reader); err != ErrWindowSizeExceeded {
+		T.Errorf("Expected ErrWindowSizeExceeded, got %v", err)
+	}
+
+	// The limit is kept when the reader is reset
+	reader.Reset(bytes.NewReader(encoded))
+	if _, err := ioutil.ReadAll(reader); err != ErrWindowSizeExceeded {
+		T.Errorf("Expected ErrWindowSizeExceeded after Reset, got %v", err)
+	}
+}
+
+func TestDecodeErrors(T *testing.T) {
+	input1, err := ioutil.ReadFile("../testdata/lcet10.txt")
+	if err != nil {
+		T.Fatal(err)
+	}
+
+	// Compress in several meta-blocks
+	params := enc.NewBrotliParams()
+	params.SetQuality(4)
+	params.SetLgblock(16)
+	encoded, err := enc.CompressBufferParallel(params, input1, nil, 0)
+	if err != nil {
+		T.Fatal(err)
+	}
+
+	// Reserved window size
+	_, err = DecompressBuffer([]byte{0x11, 0x00}, nil)
+	if e, ok := err.(*DecodeError); !ok || e.Err != ErrCorrupt || e.Reason != "reserved window size" {
+		T.Errorf("Expected corrupt input error for reserved window size, got %v", err)
+	}
+
+	// An empty last meta-block, followed by padding bits which aren't zero
+	_, err = DecompressBuffer([]byte{0x0e}, nil)
+	if e, ok := err.(*DecodeError); !ok || e.Err != ErrCorrupt || e.Reason != "non-zero padding bits at end of stream" {
+		T.Errorf("Expected corrupt input error for padding, got %v", err)
+	}
+
+	// Truncated in a later meta-block
+	truncated := encoded[:len(encoded)/2]
+	_, err = DecompressBuffer(truncated, nil)
+	e, ok := err.(*DecodeError)
+	if !ok || e.Err != ErrTruncated {
+		T.Fatalf("Expected truncated input error, got %v", err)
+	}
+	if e.Offset != int64(len(truncated)) || e.MetaBlock == 0 {
+		T.Errorf("Unexpected location for truncated input: %v", err)
+	}
+
+	_, err = ioutil.ReadAll(NewBrotliReaderSize(bytes.NewReader(truncated), 100))
+	if streamErr, ok := err.(*DecodeError); !ok || *streamErr != *e {
+		T.Errorf("Expected stream error %v, got %v", e, err)
+	}
+
+	// Corrupted in a later meta-block
+	corrupted := append([]byte(nil), encoded...)
+	for i := len(corrupted) / 2; i < len(corrupted)/2+16; i++ {
+		corrupted[i] = 0xff
+	}
+	_, err = DecompressBuffer(corrupted, nil)
+	e, ok = err.(*DecodeError)
+	if !ok || e.Err != ErrCorrupt {
+		T.Fatalf("Expected corrupt input error, got %v", err)
+	}
+	if e.Offset < int64(len(corrupted)/2) || e.MetaBlock == 0 || e.Reason == "" {
+		T.Errorf("Unexpected location for corrupt input: %v", err)
+	}
+	T.Log(err)
+
+	_, err = ioutil.ReadAll(NewBrotliReaderSize(bytes.NewReader(corrupted), 100))
+	if streamErr, ok := err.(*DecodeError); !ok || *streamErr != *e {
+		T.Errorf("Expected stream error %v, got %v", e, err)
+	}
+
+	// Misuse
+	decoder := NewDecoder()
+	decoder.Close()
+	if _, err := decoder.DecompressBuffer(encoded, nil); err != ErrDecoderClosed {
+		T.Errorf("Expected ErrDecoderClosed, got %v", err)
+	}
+
+	reader := NewBrotliReader(bytes.NewReader(encoded))
+	reader.Close()
+	if _, err := reader.Read(make([]byte, 100)); err != ErrDecoderClosed {
+		T.Errorf("Expected ErrDecoderClosed, got %v", err)
+	}
+}
@@ -82,6 +82,9 @@ void BrotliStateInitWithCustomAllocators(BrotliState* s,
 
   s->is_last_metablock = 0;
   s->window_bits = 0;
+  s->max_window_bits = 0;
+  s->metablock_count = 0;
+  s->error_reason = NULL;
   s->max_distance = 0;
   s->dist_rb[0] = 16;
   s->dist_rb[1] = 15;
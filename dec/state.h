@@ -214,6 +214,9 @@ struct BrotliStateStruct {
   uint8_t is_metadata;
   uint8_t size_nibbles;
   uint32_t window_bits;
+  uint32_t max_window_bits;
+  uint32_t metablock_count;  /* Meta-blocks started, for error reporting */
+  const char* error_reason;  /* Why decoding failed, for error reporting */
 
   uint32_t num_literal_htrees;
   uint8_t* context_map;
@@ -253,6 +253,37 @@ BrotliCompressor::BrotliCompressor(BrotliParams params)
   hashers_->Init(hash_type_);
 }
 
+void BrotliCompressor::Reset() {
+  input_pos_ = 0;
+  num_commands_ = 0;
+  num_literals_ = 0;
+  last_insert_len_ = 0;
+  last_flush_pos_ = 0;
+  last_processed_pos_ = 0;
+  prev_byte_ = 0;
+  prev_byte2_ = 0;
+
+  ringbuffer_->Reset();
+
+  // Initialize last byte with stream header.
+  EncodeWindowBits(params_.lgwin, &last_byte_, &last_byte_bits_);
+
+  // Initialize distance cache.
+  dist_cache_[0] = 4;
+  dist_cache_[1] = 11;
+  dist_cache_[2] = 15;
+  dist_cache_[3] = 16;
+  memcpy(saved_dist_cache_, dist_cache_, sizeof(dist_cache_));
+
+  if (params_.quality == 0) {
+    InitCommandPrefixCodes(cmd_depths_, cmd_bits_,
+                           cmd_code_, &cmd_code_numbits_);
+  }
+
+  // Clear the hash tables before they are next used.
+  hashers_->Reset();
+}
+
 BrotliCompressor::~BrotliCompressor() {
   delete[] storage_;
   free(commands_);
@@ -54,8 +54,21 @@ import (
 
 // Errors which may be returned when encoding
 var (
-	errInputLargerThanBlockSize = errors.New("data copied to ring buffer larger than brotli compressor block size")
-	errBrotliCompression        = errors.New("brotli compression error")
+	// ErrInputLargerThanBlockSize is returned if more data was copied to the
+	// compressor's ring buffer than its input block size.
+	ErrInputLargerThanBlockSize = errors.New("data copied to ring buffer larger than brotli compressor block size")
+
+	// ErrCompression is returned when the native encoder fails, for instance
+	// because the output buffer is too small.
+	ErrCompression = errors.New("brotli compression error")
+
+	// ErrEncoderClosed is returned when an Encoder is used after it has been
+	// closed.
+	ErrEncoderClosed = errors.New("brotli encoder is closed")
+
+	// ErrWriterClosed is returned when a BrotliWriter or ParallelWriter is
+	// written to after it has been closed.
+	ErrWriterClosed = errors.New("brotli writer is closed")
 )
 
 func init() {
@@ -76,6 +89,14 @@ const (
 	FONT
 )
 
+// Ranges of the window and block size parameters, as in encode_go.h
+const (
+	minWindowBits     = 10
+	maxWindowBits     = 24
+	minInputBlockBits = 16
+	maxInputBlockBits = 24
+)
+
 // BrotliParams describes the settings used when encoding using Brotli
 type BrotliParams struct {
 	c C.struct_CBrotliParams
@@ -148,6 +169,18 @@ func (p *BrotliParams) maxOutputSize(inputLength int) int {
 	return int(C.BrotliMaxOutputSize(p.c, C.size_t(inputLength)))
 }
 
+// The largest custom dictionary which can be referred to with the sliding
+// window, as in later versions of the upstream encoder.
+func (p *BrotliParams) maxDictionarySize() int {
+	lgwin := p.Lgwin()
+	if lgwin < minWindowBits {
+		lgwin = minWindowBits
+	} else if lgwin > maxWindowBits {
+		lgwin = maxWindowBits
+	}
+	return (1 << uint(lgwin)) - 16
+}
+
 // CompressBuffer compresses a single block of data. It uses encodedBuffer as
 // the destination buffer unless it is too small, in which case a new buffer
 // is allocated.
@@ -169,7 +202,7 @@ func CompressBuffer(params *BrotliParams, inputBuffer []byte, encodedBuffer []by
 	encodedLength := C.size_t(len(encodedBuffer))
 	result := C.CBrotliCompressBuffer(params.c, C.size_t(inputLength), toC(inputBuffer), &encodedLength, toC(encodedBuffer))
 	if result == 0 {
-		return nil, errBrotliCompression
+		return nil, ErrCompression
 	}
 	return encodedBuffer[0:encodedLength], nil
 }
@@ -198,7 +231,7 @@ func CompressBufferDict(params *BrotliParams, inputBuffer []byte, inputDict []by
 		C.size_t(dictLength), toC(inputDict),
 		&encodedLength, toC(encodedBuffer))
 	if result == 0 {
-		return nil, errBrotliCompression
+		return nil, ErrCompression
 	}
 	return encodedBuffer[0:encodedLength], nil
 }
@@ -208,7 +241,8 @@ type brotliCompressor struct {
 	outputBuffer []byte
 }
 
-// An instance can not be reused for multiple brotli streams.
+// An instance can be reused for multiple brotli streams by calling reset()
+// between them.
 func newBrotliCompressor(params *BrotliParams) *brotliCompressor {
 	if params == nil {
 		params = NewBrotliParams()
@@ -223,6 +257,21 @@ func newBrotliCompressor(params *BrotliParams) *brotliCompressor {
 	return bp
 }
 
+// Prepares the compressor for a new brotli stream, keeping its ring buffer,
+// hash tables and output buffer.
+func (bp *brotliCompressor) reset() {
+	C.CBrotliCompressorReset(bp.c)
+}
+
+// Fills the ring buffer and hash tables with a custom dictionary, which must
+// be done at the start of a stream. The dictionary is copied by the compressor.
+func (bp *brotliCompressor) setCustomDictionary(dict []byte) {
+	if len(dict) == 0 {
+		return
+	}
+	C.CBrotliCompressorSetCustomDictionary(bp.c, C.size_t(len(dict)), toC(dict))
+}
+
 // The maximum input size that can be processed at once.
 func (bp *brotliCompressor) getInputBlockSize() int {
 	return int(C.CBrotliCompressorGetInputBlockSize(bp.c))
@@ -248,7 +297,7 @@ func (bp *brotliCompressor) writeBrotliData(isLast bool, forceFlush bool) ([]byt
 	var output *C.uint8_t
 	success := C.CBrotliCompressorWriteBrotliData(bp.c, C.bool(isLast), C.bool(forceFlush), &outSize, &output)
 	if success == false {
-		return nil, errInputLargerThanBlockSize
+		return nil, ErrInputLargerThanBlockSize
 	}
 
 	// resize buffer if output is larger than we've anticipated
@@ -260,6 +309,69 @@ func (bp *brotliCompressor) writeBrotliData(isLast bool, forceFlush bool) ([]byt
 	return bp.outputBuffer[:outSize], nil
 }
 
+// Processes the accumulated input data into a new output meta-block, followed
+// by an empty metadata meta-block which pads the output to a byte boundary.
+// All of the input copied so far can then be decoded from the output, while
+// the sliding window is kept for subsequent meta-blocks.
+func (bp *brotliCompressor) flush() ([]byte, error) {
+	compressedData, err := bp.writeBrotliData(false, true)
+	if err != nil {
+		return nil, err
+	}
+
+	// an empty metadata meta-block needs at most 6 bytes, including the
+	// pending bits of the last byte
+	outSize := len(compressedData)
+	if outSize+6 > cap(bp.outputBuffer) {
+		bp.outputBuffer = make([]byte, outSize+6)
+		copy(bp.outputBuffer, compressedData)
+	}
+	bp.outputBuffer = bp.outputBuffer[:cap(bp.outputBuffer)]
+
+	metadataSize := C.size_t(len(bp.outputBuffer) - outSize)
+	success := C.CBrotliCompressorWriteMetadata(bp.c, 0, nil, false, &metadataSize, toC(bp.outputBuffer[outSize:]))
+	if success == false {
+		return nil, ErrCompression
+	}
+	return bp.outputBuffer[:outSize+int(metadataSize)], nil
+}
+
+// Compresses a complete stream one input block at a time, appending the output
+// to encodedBuffer[:0]. If cancelled is not nil, it is called before each block
+// and compression stops if it returns an error.
+func (bp *brotliCompressor) compressBuffer(inputBuffer []byte, encodedBuffer []byte, cancelled func() error) ([]byte, error) {
+	blockSize := bp.getInputBlockSize()
+	encodedBuffer = encodedBuffer[:0]
+
+	for pos := 0; ; {
+		if cancelled != nil {
+			if err := cancelled(); err != nil {
+				return nil, err
+			}
+		}
+
+		copySize := len(inputBuffer) - pos
+		if copySize > blockSize {
+			copySize = blockSize
+		}
+		if copySize > 0 {
+			bp.copyInputToRingBuffer(inputBuffer[pos : pos+copySize])
+			pos += copySize
+		}
+
+		isLast := pos == len(inputBuffer)
+		compressedData, err := bp.writeBrotliData(isLast, false)
+		if err != nil {
+			return nil, err
+		}
+		encodedBuffer = append(encodedBuffer, compressedData...)
+
+		if isLast {
+			return encodedBuffer, nil
+		}
+	}
+}
+
 func (bp *brotliCompressor) free() {
 	if bp.c == nil {
 		return
@@ -275,30 +387,102 @@ func brotliCompressorFinalizer(bp *brotliCompressor) {
 // BrotliWriter implements the io.Writer interface, compressing the stream
 // to an output Writer using Brotli.
 type BrotliWriter struct {
+	params     BrotliParams
+	dict       []byte
 	compressor *brotliCompressor
 	writer     io.Writer
 
 	// amount of data already copied into ring buffer
 	inRingBuffer int
+
+	// whether data has been written since the last flush
+	unflushed bool
+
+	// whether the end of the stream has been written by Finish
+	finished bool
+
+	// block-sized buffer for ReadFrom
+	readBuffer []byte
+
+	// returns an error once the stream has been cancelled, if not nil
+	cancelled func() error
 }
 
 // NewBrotliWriter instantiates a new BrotliWriter with the provided compression
 // parameters and output Writer
 func NewBrotliWriter(params *BrotliParams, writer io.Writer) *BrotliWriter {
+	if params == nil {
+		params = NewBrotliParams()
+	}
+
 	return &BrotliWriter{
+		params:       *params,
 		compressor:   newBrotliCompressor(params),
 		writer:       writer,
 		inRingBuffer: 0,
 	}
 }
 
+// NewBrotliWriterDict instantiates a new BrotliWriter with the provided
+// compression parameters and output Writer, which uses a custom dictionary.
+// The stream must be decompressed using the same dictionary, for example with
+// dec.NewBrotliReaderDict or dec.DecompressBufferDict.
+//
+// Only the last (1 << lgwin) - 16 bytes of the dictionary can be referred to
+// by the compressed stream, so any preceding data is ignored.
+func NewBrotliWriterDict(params *BrotliParams, dict []byte, writer io.Writer) *BrotliWriter {
+	w := NewBrotliWriter(params, writer)
+
+	// Keep a copy to set up the compressor again on Reset
+	if maxSize := w.params.maxDictionarySize(); len(dict) > maxSize {
+		dict = dict[len(dict)-maxSize:]
+	}
+	w.dict = append([]byte(nil), dict...)
+	w.compressor.setCustomDictionary(w.dict)
+
+	return w
+}
+
+// Reset discards the state of the BrotliWriter and makes it equivalent to the
+// result of NewBrotliWriter with the original parameters, writing to writer
+// instead. The native compressor state is reinitialised in place rather than
+// allocated again, unless it has already been released by Close.
+// A custom dictionary passed to NewBrotliWriterDict is kept, as is the context
+// passed to NewBrotliWriterContext.
+func (w *BrotliWriter) Reset(writer io.Writer) {
+	if w.compressor.c == nil {
+		w.compressor = newBrotliCompressor(&w.params)
+	} else {
+		w.compressor.reset()
+	}
+	w.compressor.setCustomDictionary(w.dict)
+	w.writer = writer
+	w.inRingBuffer = 0
+	w.unflushed = false
+	w.finished = false
+}
+
 func (w *BrotliWriter) Write(buffer []byte) (int, error) {
 	comp := w.compressor
+	if comp.c == nil || w.finished {
+		return 0, ErrWriterClosed
+	}
+	if err := w.checkCancelled(); err != nil {
+		return 0, err
+	}
 	blockSize := int(comp.getInputBlockSize())
 	roomFor := blockSize - w.inRingBuffer
 	copied := 0
 
+	if len(buffer) > 0 {
+		w.unflushed = true
+	}
+
 	for len(buffer) >= roomFor {
+		if err := w.checkCancelled(); err != nil {
+			return copied, err
+		}
+
 		comp.copyInputToRingBuffer(buffer[:roomFor])
 		copied += roomFor
 
@@ -327,9 +511,88 @@ func (w *BrotliWriter) Write(buffer []byte) (int, error) {
 	return copied, nil
 }
 
+// ReadFrom implements the io.ReaderFrom interface, so that io.Copy compresses
+// directly from src. Input is read in whole blocks, so each block is copied
+// to the compressor's ring buffer in one go.
+// Returns the number of bytes read from src, or an error.
+func (w *BrotliWriter) ReadFrom(src io.Reader) (int64, error) {
+	if w.compressor.c == nil || w.finished {
+		return 0, ErrWriterClosed
+	}
+
+	blockSize := w.compressor.getInputBlockSize()
+	if len(w.readBuffer) < blockSize {
+		w.readBuffer = make([]byte, blockSize)
+	}
+
+	var total int64
+	for {
+		// Top up the block already in the ring buffer
+		read, err := io.ReadFull(src, w.readBuffer[:blockSize-w.inRingBuffer])
+		if read > 0 {
+			copied, werr := w.Write(w.readBuffer[:read])
+			total += int64(copied)
+			if werr != nil {
+				return total, werr
+			}
+		}
+
+		switch err {
+		case nil:
+		case io.EOF, io.ErrUnexpectedEOF:
+			return total, nil
+		default:
+			return total, err
+		}
+	}
+}
+
+// Flush compresses any pending data and writes it to the output Writer as
+// a complete meta-block, so that everything written so far can be decoded by
+// the other end. The sliding window is kept, so data written after a flush
+// can still refer back to data written before it.
+func (w *BrotliWriter) Flush() error {
+	if w.compressor.c == nil || w.finished {
+		return ErrWriterClosed
+	}
+	if !w.unflushed {
+		return nil
+	}
+	if err := w.checkCancelled(); err != nil {
+		return err
+	}
+
+	compressedData, err := w.compressor.flush()
+	if err != nil {
+		return err
+	}
+	w.inRingBuffer = 0
+	w.unflushed = false
+
+	_, err = w.writer.Write(compressedData)
+	return err
+}
+
 // Close cleans up the resources used by the Brotli encoder for this
 // stream. If the output buffer is an io.Closer, it will also be closed.
+// Closing a BrotliWriter which is already closed has no effect.
 func (w *BrotliWriter) Close() error {
+	if w.compressor.c == nil {
+		return nil
+	}
+	if w.finished {
+		w.compressor.free()
+		if v, ok := w.writer.(io.Closer); ok {
+			return v.Close()
+		}
+		return nil
+	}
+	if err := w.checkCancelled(); err != nil {
+		// Don't write the end of the stream, so it can't be mistaken for a
+		// complete one
+		w.compressor.free()
+		return err
+	}
 	compressedData, err := w.compressor.writeBrotliData(true, false)
 	if err != nil {
 		return err
@@ -348,8 +611,174 @@ func (w *BrotliWriter) Close() error {
 	return nil
 }
 
+// Finish writes the end of the stream like Close, but keeps the native
+// compressor state so that Reset can reuse it for another stream, which saves
+// allocating it again when compressing many small streams. The output Writer
+// is not closed. Write and Flush fail with ErrWriterClosed until the
+// BrotliWriter is Reset, and Close releases the native state as usual.
+func (w *BrotliWriter) Finish() error {
+	if w.compressor.c == nil || w.finished {
+		return ErrWriterClosed
+	}
+	if err := w.checkCancelled(); err != nil {
+		return err
+	}
+
+	compressedData, err := w.compressor.writeBrotliData(true, false)
+	w.finished = true
+	if err != nil {
+		return err
+	}
+	_, err = w.writer.Write(compressedData)
+	return err
+}
+
+// CompressingReader implements the io.Reader interface, reading from a source
+// Reader and returning the data compressed with Brotli. The compressor is
+// driven by calls to Read, so no goroutine or pipe is needed to turn a
+// BrotliWriter into a Reader.
+type CompressingReader struct {
+	compressor *brotliCompressor
+	src        io.Reader
+
+	// block-sized buffer for input read from src
+	inputBuffer []byte
+
+	// compressed data which has not been read yet
+	pending []byte
+
+	// persistent error, io.EOF once the last block has been read
+	err error
+}
+
+// NewCompressingReader instantiates a new CompressingReader with the provided
+// compression parameters, which reads and compresses the data from src.
+// Default parameters are used if params is nil.
+//
+// Input is read from src a whole block at a time, so a Read call may have to
+// wait for up to (1 << lgblock) bytes of input before returning any data.
+func NewCompressingReader(params *BrotliParams, src io.Reader) *CompressingReader {
+	compressor := newBrotliCompressor(params)
+	return &CompressingReader{
+		compressor:  compressor,
+		src:         src,
+		inputBuffer: make([]byte, compressor.getInputBlockSize()),
+	}
+}
+
+// Read fills p with compressed data, reading more input from the source
+// Reader and compressing it as needed.
+func (r *CompressingReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if err := r.compressBlock(); err != nil {
+			r.err = err
+			r.compressor.free()
+		}
+	}
+
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// Reads a block of input from src, and compresses it into pending. Returns
+// io.EOF once the end of the stream has been compressed.
+func (r *CompressingReader) compressBlock() error {
+	comp := r.compressor
+	read, err := io.ReadFull(r.src, r.inputBuffer)
+	isLast := err == io.EOF || err == io.ErrUnexpectedEOF
+	if err != nil && !isLast {
+		return err
+	}
+
+	if read > 0 {
+		comp.copyInputToRingBuffer(r.inputBuffer[:read])
+	}
+	compressedData, err := comp.writeBrotliData(isLast, false)
+	if err != nil {
+		return err
+	}
+
+	// The output buffer isn't reused until the next block
+	r.pending = compressedData
+	if isLast {
+		return io.EOF
+	}
+	return nil
+}
+
+// Close cleans up the resources used by the Brotli encoder. If the source
+// Reader is an io.Closer, it will also be closed.
+func (r *CompressingReader) Close() error {
+	if r.err == ErrEncoderClosed {
+		return nil
+	}
+	r.compressor.free()
+	r.pending = nil
+	if r.err == nil || r.err == io.EOF {
+		r.err = ErrEncoderClosed // Make sure future operations fail
+	}
+
+	if v, ok := r.src.(io.Closer); ok {
+		return v.Close()
+	}
+
+	return nil
+}
+
+// Returns an error if the stream has been cancelled
+func (w *BrotliWriter) checkCancelled() error {
+	if w.cancelled == nil {
+		return nil
+	}
+	return w.cancelled()
+}
+
+// Encoder compresses single blocks of data like CompressBuffer, but keeps its
+// native compressor state, hash tables and buffers between calls. An Encoder
+// must not be used from multiple goroutines at the same time, but it may be
+// kept in a sync.Pool and reused.
+//
+// Ensure that you Close the Encoder when you are finished with it in order
+// to release the native compressor state.
+type Encoder struct {
+	compressor *brotliCompressor
+}
+
+// NewEncoder instantiates an Encoder with the provided compression parameters.
+// Default parameters are used if params is nil.
+func NewEncoder(params *BrotliParams) *Encoder {
+	return &Encoder{compressor: newBrotliCompressor(params)}
+}
+
+// CompressBuffer compresses a single block of data. It uses encodedBuffer as
+// the destination buffer unless it is too small, in which case a new buffer
+// is allocated.
+// Returns the slice of the encodedBuffer containing the output, or an error.
+func (e *Encoder) CompressBuffer(inputBuffer []byte, encodedBuffer []byte) ([]byte, error) {
+	comp := e.compressor
+	if comp.c == nil {
+		return nil, ErrEncoderClosed
+	}
+	comp.reset()
+
+	return comp.compressBuffer(inputBuffer, encodedBuffer, nil)
+}
+
+// Close releases the native compressor state used by the Encoder.
+func (e *Encoder) Close() error {
+	e.compressor.free()
+	return nil
+}
+
 // internal cgo utilities
 
 func toC(array []byte) *C.uint8_t {
+	if len(array) == 0 {
+		return nil
+	}
 	return (*C.uint8_t)(unsafe.Pointer(&array[0]))
 }
@@ -131,6 +131,11 @@ class BrotliCompressor {
   // No-op, but we keep it here for API backward-compatibility.
   void WriteStreamHeader() {}
 
+  // Resets the compressor to the state of a newly constructed instance with
+  // the same parameters, so that it can be used for a new brotli stream. The
+  // ring buffer, hash tables and output storage are kept.
+  void Reset();
+
  private:
   uint8_t* GetBrotliStorage(size_t size);
 
@@ -0,0 +1,60 @@
+//go:build go1.7
+// +build go1.7
+
+package enc
+
+import (
+	"context"
+	"io"
+)
+
+// The largest input block compressed in one go by the context-aware functions
+// at high quality, unless lgblock is set, so that cancellation is noticed
+// promptly
+const contextMaxBlockBits = 18
+
+// Limits the input block size for the context-aware functions.
+func contextParams(params *BrotliParams) *BrotliParams {
+	if params == nil {
+		params = NewBrotliParams()
+	}
+
+	p := *params
+	if p.Lgblock() == 0 && p.Quality() >= 9 && p.Lgwin() > contextMaxBlockBits {
+		p.SetLgblock(contextMaxBlockBits)
+	}
+	return &p
+}
+
+// CompressBufferContext compresses a single block of data like CompressBuffer,
+// but returns ctx.Err() as soon as ctx is cancelled. The input is compressed
+// one block of (1 << lgblock) bytes at a time, and the context is checked
+// before each block. Unless lgblock is set, blocks of at most 256kb are used.
+//
+// It uses encodedBuffer as the destination buffer unless it is too small, in
+// which case a new buffer is allocated. No output is returned if ctx is
+// cancelled, though encodedBuffer may have been written to.
+// Default parameters are used if params is nil.
+func CompressBufferContext(ctx context.Context, params *BrotliParams, inputBuffer []byte, encodedBuffer []byte) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	comp := newBrotliCompressor(contextParams(params))
+	defer comp.free()
+
+	return comp.compressBuffer(inputBuffer, encodedBuffer, ctx.Err)
+}
+
+// NewBrotliWriterContext instantiates a new BrotliWriter like NewBrotliWriter,
+// which stops compressing and returns ctx.Err() from Write, Flush and Close
+// once ctx is cancelled. The context is checked before each input block is
+// compressed, and unless lgblock is set, blocks of at most 256kb are used.
+//
+// If ctx is cancelled, Close releases the compressor without writing the end
+// of the stream or closing the output Writer.
+func NewBrotliWriterContext(ctx context.Context, params *BrotliParams, writer io.Writer) *BrotliWriter {
+	w := NewBrotliWriter(contextParams(params), writer)
+	w.cancelled = ctx.Err
+	return w
+}
@@ -0,0 +1,65 @@
+//go:build go1.7
+// +build go1.7
+
+package enc
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestCompressBufferContext(T *testing.T) {
+	input1 := []byte(strings.Repeat("The quick brown fox jumps over the lazy dog", 100000))
+
+	params := NewBrotliParams()
+	params.SetQuality(9)
+
+	output, err := CompressBufferContext(context.Background(), params, input1, nil)
+	if err != nil {
+		T.Fatal(err)
+	}
+
+	// The same blocks are used as for a BrotliWriter with the same context
+	var expected bytes.Buffer
+	writer := NewBrotliWriterContext(context.Background(), params, &expected)
+	if _, err := writer.Write(input1); err != nil {
+		T.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		T.Fatal(err)
+	}
+	if !bytes.Equal(output, expected.Bytes()) {
+		T.Error("CompressBufferContext didn't give same result as BrotliWriter")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	output, err = CompressBufferContext(ctx, params, input1, nil)
+	if err != context.Canceled || output != nil {
+		T.Errorf("Expected context.Canceled and no output, got %v and %d bytes", err, len(output))
+	}
+}
+
+func TestWriterContext(T *testing.T) {
+	input1 := []byte(strings.Repeat("The quick brown fox jumps over the lazy dog", 100000))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var output bytes.Buffer
+	writer := NewBrotliWriterContext(ctx, nil, &output)
+	if _, err := writer.Write(input1[:1000]); err != nil {
+		T.Fatal(err)
+	}
+	cancel()
+
+	if _, err := writer.Write(input1); err != context.Canceled {
+		T.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if err := writer.Close(); err != context.Canceled {
+		T.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if output.Len() != 0 {
+		T.Errorf("Expected no output after cancellation, got %d bytes", output.Len())
+	}
+}
@@ -1,5 +1,6 @@
 #include "./encode.h"
 #include "./encode_go.h"
+#include "./encode_parallel.h"
 
 using namespace brotli;
 
@@ -37,6 +38,47 @@ int CBrotliCompressBufferDict(CBrotliParams params,
    return 1;
 }
 
+void CBrotliSanitizeParallelParams(CBrotliParams* params) {
+  // Quality 0 and 1 use a separate fast path which the parallel compressor
+  // does not support.
+  if (params->quality < 2) {
+    params->quality = 2;
+  }
+  if (params->lgwin < brotli::kMinWindowBits) {
+    params->lgwin = brotli::kMinWindowBits;
+  } else if (params->lgwin > brotli::kMaxWindowBits) {
+    params->lgwin = brotli::kMaxWindowBits;
+  }
+  if (params->lgblock == 0) {
+    params->lgblock = 16;
+    if (params->quality >= 9 && params->lgwin > params->lgblock) {
+      params->lgblock = params->lgwin < 21 ? params->lgwin : 21;
+    }
+  } else if (params->lgblock < brotli::kMinInputBlockBits) {
+    params->lgblock = brotli::kMinInputBlockBits;
+  } else if (params->lgblock > brotli::kMaxInputBlockBits) {
+    params->lgblock = brotli::kMaxInputBlockBits;
+  }
+}
+
+int CBrotliCompressBlockParallel(CBrotliParams params,
+                         size_t input_size,
+                         const uint8_t* input_buffer,
+                         size_t prefix_size,
+                         const uint8_t* prefix_buffer,
+                         bool is_first,
+                         bool is_last,
+                         size_t* encoded_size,
+                         uint8_t* encoded_buffer) {
+  return BrotliCompressBlockParallel(
+    *((BrotliParams*) &params),
+    input_size, input_buffer,
+    prefix_size, prefix_buffer,
+    is_first, is_last,
+    encoded_size, encoded_buffer
+  );
+}
+
 CBrotliCompressor CBrotliCompressorNew(CBrotliParams params) {
   BrotliCompressor *ret = new BrotliCompressor(*((BrotliParams*) &params));
   return (CBrotliCompressor) ret;
@@ -47,6 +89,16 @@ void CBrotliCompressorFree(CBrotliCompressor cbp) {
   delete bp;
 }
 
+void CBrotliCompressorReset(CBrotliCompressor cbp) {
+  BrotliCompressor *bp = (BrotliCompressor *)cbp;
+  bp->Reset();
+}
+
+void CBrotliCompressorSetCustomDictionary(CBrotliCompressor cbp, const size_t dict_size, const uint8_t* dict_buffer) {
+  BrotliCompressor *bp = (BrotliCompressor *)cbp;
+  bp->BrotliSetCustomDictionary(dict_size, dict_buffer);
+}
+
 size_t CBrotliCompressorGetInputBlockSize(CBrotliCompressor cbp) {
   BrotliCompressor *bp = (BrotliCompressor *)cbp;
   return bp->input_block_size();
@@ -61,3 +113,8 @@ bool CBrotliCompressorWriteBrotliData(CBrotliCompressor cbp, const bool is_last,
   BrotliCompressor *bp = (BrotliCompressor *)cbp;
   return bp->WriteBrotliData(is_last, force_flush, out_size, output);
 }
+
+bool CBrotliCompressorWriteMetadata(CBrotliCompressor cbp, const size_t input_size, const uint8_t* input_buffer, const bool is_last, size_t* encoded_size, uint8_t* encoded_buffer) {
+  BrotliCompressor *bp = (BrotliCompressor *)cbp;
+  return bp->WriteMetadata(input_size, input_buffer, is_last, encoded_size, encoded_buffer);
+}
@@ -61,14 +61,47 @@ int CBrotliCompressBufferDict(CBrotliParams params,
                          size_t* encoded_size,
                          uint8_t* encoded_buffer);
 
+// Parallel API
+
+// Sanitizes the window and block sizes in the same way as the compressor, and
+// raises the quality to the minimum supported by the parallel compressor.
+void CBrotliSanitizeParallelParams(CBrotliParams* params);
+
+// Compresses a block of input_buffer into encoded_buffer, and sets
+// *encoded_size to the compressed length. The output ends at a byte boundary
+// so that blocks compressed in parallel can be concatenated into one stream.
+// Backward references can be made into prefix_buffer, which should hold the
+// input preceding the block. The params must have been sanitized with
+// CBrotliSanitizeParallelParams.
+// Returns 0 if there was an error and 1 otherwise.
+int CBrotliCompressBlockParallel(CBrotliParams params,
+                         size_t input_size,
+                         const uint8_t* input_buffer,
+                         size_t prefix_size,
+                         const uint8_t* prefix_buffer,
+                         bool is_first,
+                         bool is_last,
+                         size_t* encoded_size,
+                         uint8_t* encoded_buffer);
+
 // Streaming API
 typedef void* CBrotliCompressor;
 
-// An instance can not be reused for multiple brotli streams.
+// An instance can be reused for multiple brotli streams by calling
+// CBrotliCompressorReset between them.
 CBrotliCompressor CBrotliCompressorNew(CBrotliParams params);
 
 void CBrotliCompressorFree(CBrotliCompressor cbp);
 
+// Resets the compressor to the state of a newly created instance with the
+// same parameters, keeping its allocated buffers and hash tables.
+void CBrotliCompressorReset(CBrotliCompressor cbp);
+
+// Fills the new state with a dictionary for LZ77, warming up the ringbuffer,
+// e.g. for custom static dictionaries for data formats.
+// The dictionary data is copied, so it does not need to be kept in memory.
+void CBrotliCompressorSetCustomDictionary(CBrotliCompressor cbp, const size_t dict_size, const uint8_t* dict_buffer);
+
 // The maximum input size that can be processed at once.
 size_t CBrotliCompressorGetInputBlockSize(CBrotliCompressor cbp);
 
@@ -88,6 +121,14 @@ void CBrotliCompressorCopyInputToRingBuffer(CBrotliCompressor cbp, const size_t
 // If is_last or force_flush is true, an output meta-block is always created.
 bool CBrotliCompressorWriteBrotliData(CBrotliCompressor cbp, const bool is_last, const bool force_flush, size_t* out_size, uint8_t** output);
 
+// Writes a metadata meta-block containing the given input to encoded_buffer.
+// *encoded_size should be set to the size of the encoded_buffer.
+// Sets *encoded_size to the number of bytes that was written.
+// Note that the given input data will not be part of the sliding window and
+// thus no backward references can be made to this data from subsequent
+// metablocks.
+bool CBrotliCompressorWriteMetadata(CBrotliCompressor cbp, const size_t input_size, const uint8_t* input_buffer, const bool is_last, size_t* encoded_size, uint8_t* encoded_buffer);
+
 #ifdef __cplusplus
 }
 #endif
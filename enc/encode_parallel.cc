@@ -153,9 +153,12 @@ bool WriteMetaBlockParallel(const BrotliParams& params,
     } else if (params.lgwin == 17) {
       first_byte = 1;
       first_byte_bits = 7;
-    } else {
+    } else if (params.lgwin > 17) {
       first_byte = static_cast<uint8_t>(((params.lgwin - 17) << 1) | 1);
       first_byte_bits = 4;
+    } else {
+      first_byte = static_cast<uint8_t>(((params.lgwin - 8) << 4) | 1);
+      first_byte_bits = 7;
     }
   }
   storage[0] = static_cast<uint8_t>(first_byte);
@@ -276,4 +279,24 @@ int BrotliCompressBufferParallel(BrotliParams params,
   return true;
 }
 
+int BrotliCompressBlockParallel(const BrotliParams& params,
+                                size_t input_size,
+                                const uint8_t* input_buffer,
+                                size_t prefix_size,
+                                const uint8_t* prefix_buffer,
+                                bool is_first,
+                                bool is_last,
+                                size_t* encoded_size,
+                                uint8_t* encoded_buffer) {
+  return WriteMetaBlockParallel(params,
+                                static_cast<uint32_t>(input_size),
+                                input_buffer,
+                                static_cast<uint32_t>(prefix_size),
+                                prefix_buffer,
+                                is_first,
+                                is_last,
+                                encoded_size,
+                                encoded_buffer);
+}
+
 }  // namespace brotli
@@ -0,0 +1,309 @@
+package enc
+
+/*
+#include "./encode_go.h"
+*/
+import "C"
+
+import (
+	"io"
+	"runtime"
+	"sync"
+)
+
+// Settings for compressing blocks independently, cf. encode_parallel.cc
+type parallelParams struct {
+	c C.struct_CBrotliParams
+}
+
+func newParallelParams(params *BrotliParams) parallelParams {
+	if params == nil {
+		params = NewBrotliParams()
+	}
+
+	pp := parallelParams{params.c}
+	C.CBrotliSanitizeParallelParams(&pp.c)
+	return pp
+}
+
+// The size of the blocks which are compressed independently.
+func (pp parallelParams) blockSize() int {
+	return 1 << uint(pp.c.lgblock)
+}
+
+// The amount of preceding input which blocks can refer back to.
+func (pp parallelParams) maxPrefixSize() int {
+	return 1 << uint(pp.c.lgwin)
+}
+
+// Compresses a block of input, which can refer back to the data in prefix.
+// The output ends at a byte boundary, so that it can be concatenated with
+// the output for the preceding and following blocks.
+func (pp parallelParams) compressBlock(input, prefix []byte, isFirst, isLast bool) ([]byte, error) {
+	inputLength := len(input)
+	encodedBuffer := make([]byte, inputLength+(inputLength>>3)+1024)
+
+	prefixBuffer := (*C.uint8_t)(nil)
+	if len(prefix) > 0 {
+		prefixBuffer = toC(prefix)
+	}
+
+	encodedLength := C.size_t(len(encodedBuffer))
+	result := C.CBrotliCompressBlockParallel(pp.c,
+		C.size_t(inputLength), toC(input),
+		C.size_t(len(prefix)), prefixBuffer,
+		C.bool(isFirst), C.bool(isLast),
+		&encodedLength, toC(encodedBuffer))
+	if result == 0 {
+		return nil, ErrCompression
+	}
+	return encodedBuffer[:encodedLength], nil
+}
+
+// CompressBufferParallel compresses a single block of data in the same way as
+// CompressBuffer, but splits the input into blocks of (1 << lgblock) bytes
+// which are compressed concurrently by up to workers goroutines. Each block
+// can still refer back to the preceding input within the sliding window, and
+// the output is a single Brotli stream which can be read by any decoder.
+// The output is usually slightly larger than the output of CompressBuffer.
+// Quality values below 2 are treated as 2.
+//
+// It uses encodedBuffer as the destination buffer unless it is too small, in
+// which case a new buffer is allocated.
+// Default parameters are used if params is nil, and runtime.GOMAXPROCS(0)
+// workers are used if workers is less than 1.
+// Returns the slice of the encodedBuffer containing the output, or an error.
+func CompressBufferParallel(params *BrotliParams, inputBuffer []byte, encodedBuffer []byte, workers int) ([]byte, error) {
+	if len(inputBuffer) == 0 {
+		// An empty stream, as written by BrotliCompressBufferParallel
+		return append(encodedBuffer[:0], 6), nil
+	}
+
+	pp := newParallelParams(params)
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	blockSize := pp.blockSize()
+	maxPrefixSize := pp.maxPrefixSize()
+	blocks := (len(inputBuffer) + blockSize - 1) / blockSize
+	if workers > blocks {
+		workers = blocks
+	}
+
+	compressed := make([][]byte, blocks)
+	errs := make([]error, blocks)
+	next := make(chan int)
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for block := range next {
+				pos := block * blockSize
+				end := pos + blockSize
+				if end > len(inputBuffer) {
+					end = len(inputBuffer)
+				}
+				prefixPos := pos - maxPrefixSize
+				if prefixPos < 0 {
+					prefixPos = 0
+				}
+
+				compressed[block], errs[block] = pp.compressBlock(inputBuffer[pos:end], inputBuffer[prefixPos:pos], pos == 0, end == len(inputBuffer))
+			}
+		}()
+	}
+	for block := 0; block < blocks; block++ {
+		next <- block
+	}
+	close(next)
+	wg.Wait()
+
+	// Piece together the output
+	encodedLength := 0
+	for block, data := range compressed {
+		if errs[block] != nil {
+			return nil, errs[block]
+		}
+		encodedLength += len(data)
+	}
+
+	if len(encodedBuffer) < encodedLength {
+		encodedBuffer = make([]byte, encodedLength)
+	}
+	pos := 0
+	for _, data := range compressed {
+		pos += copy(encodedBuffer[pos:], data)
+	}
+	return encodedBuffer[:encodedLength], nil
+}
+
+// ParallelWriter implements the io.Writer interface, compressing the stream
+// to an output Writer using Brotli in the same way as CompressBufferParallel.
+// The input is split into blocks of (1 << lgblock) bytes which are compressed
+// concurrently, and written to the output Writer in order as a single Brotli
+// stream.
+//
+// The memory used is bounded by the number of workers: apart from the output,
+// each block being compressed holds at most the block and a copy of the
+// sliding window, and the writer keeps the last sliding window of input.
+type ParallelWriter struct {
+	pp      parallelParams
+	writer  io.Writer
+	workers int
+
+	chunk       []byte   // input for the next block
+	history     [][]byte // preceding blocks within the sliding window, oldest first
+	historySize int
+	started     bool // whether the first block has been compressed
+	pending     []chan parallelResult
+
+	err error
+}
+
+type parallelResult struct {
+	data []byte
+	err  error
+}
+
+// NewParallelWriter instantiates a new ParallelWriter with the provided
+// compression parameters and output Writer, which compresses up to workers
+// blocks at once.
+// Default parameters are used if params is nil, and runtime.GOMAXPROCS(0)
+// workers are used if workers is less than 1.
+func NewParallelWriter(params *BrotliParams, writer io.Writer, workers int) *ParallelWriter {
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	pp := newParallelParams(params)
+	return &ParallelWriter{
+		pp:      pp,
+		writer:  writer,
+		workers: workers,
+		chunk:   make([]byte, 0, pp.blockSize()),
+	}
+}
+
+func (w *ParallelWriter) Write(buffer []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	copied := 0
+	for len(buffer) > 0 {
+		// Only compress a full block once more input arrives, since we don't
+		// know whether it is the last one until then
+		if len(w.chunk) == cap(w.chunk) {
+			if err := w.compressChunk(false); err != nil {
+				return copied, err
+			}
+		}
+
+		n := cap(w.chunk) - len(w.chunk)
+		if n > len(buffer) {
+			n = len(buffer)
+		}
+		w.chunk = append(w.chunk, buffer[:n]...)
+		buffer = buffer[n:]
+		copied += n
+	}
+
+	return copied, nil
+}
+
+// Starts compressing the current chunk in the background, and writes out the
+// oldest compressed block if the maximum number of workers are busy.
+func (w *ParallelWriter) compressChunk(isLast bool) error {
+	pp := w.pp
+	input := w.chunk
+	history := w.history
+	isFirst := !w.started
+
+	result := make(chan parallelResult, 1)
+	go func() {
+		// Assemble the part of the preceding input within the sliding window
+		var prefix []byte
+		for _, block := range history {
+			prefix = append(prefix, block...)
+		}
+		if len(prefix) > pp.maxPrefixSize() {
+			prefix = prefix[len(prefix)-pp.maxPrefixSize():]
+		}
+
+		data, err := pp.compressBlock(input, prefix, isFirst, isLast)
+		result <- parallelResult{data, err}
+	}()
+	w.pending = append(w.pending, result)
+	w.started = true
+
+	// Blocks are not modified once they have been passed to a worker, so they
+	// can be shared with later workers
+	w.history = append(w.history, input)
+	w.historySize += len(input)
+	for w.historySize-len(w.history[0]) >= pp.maxPrefixSize() {
+		w.historySize -= len(w.history[0])
+		w.history = w.history[1:]
+	}
+	w.chunk = make([]byte, 0, pp.blockSize())
+
+	if len(w.pending) >= w.workers {
+		return w.writeResult()
+	}
+	return nil
+}
+
+// Waits for the oldest block to be compressed, and writes it to the output.
+func (w *ParallelWriter) writeResult() error {
+	result := <-w.pending[0]
+	w.pending = w.pending[1:]
+
+	if result.err == nil {
+		_, result.err = w.writer.Write(result.data)
+	}
+	if result.err != nil {
+		w.err = result.err
+	}
+	return result.err
+}
+
+// Close compresses the remaining input and waits for all blocks to be
+// written to the output Writer. If the output Writer is an io.Closer, it
+// will also be closed. Closing a ParallelWriter which is already closed has
+// no effect.
+func (w *ParallelWriter) Close() error {
+	if w.err == ErrWriterClosed {
+		return nil
+	}
+	if w.err != nil {
+		return w.err
+	}
+
+	if !w.started && len(w.chunk) == 0 {
+		// An empty stream, as written by BrotliCompressBufferParallel
+		if _, err := w.writer.Write([]byte{6}); err != nil {
+			w.err = err
+			return err
+		}
+	} else {
+		if err := w.compressChunk(true); err != nil {
+			return err
+		}
+		for len(w.pending) > 0 && w.err == nil {
+			w.writeResult()
+		}
+		if w.err != nil {
+			return w.err
+		}
+	}
+	w.err = ErrWriterClosed
+	w.history = nil
+
+	if v, ok := w.writer.(io.Closer); ok {
+		return v.Close()
+	}
+
+	return nil
+}
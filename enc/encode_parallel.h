@@ -23,6 +23,22 @@ int BrotliCompressBufferParallel(BrotliParams params,
                                  size_t* encoded_size,
                                  uint8_t* encoded_buffer);
 
+// Compresses a single block of input as one or more meta-blocks ending at a
+// byte boundary, so that the blocks of a stream can be compressed
+// independently and concatenated. Backward references can be made to the
+// prefix, which should hold the input preceding the block.
+// The params must already have been sanitized, and quality must be at least 2.
+// Returns 0 if there was an error and 1 otherwise.
+int BrotliCompressBlockParallel(const BrotliParams& params,
+                                size_t input_size,
+                                const uint8_t* input_buffer,
+                                size_t prefix_size,
+                                const uint8_t* prefix_buffer,
+                                bool is_first,
+                                bool is_last,
+                                size_t* encoded_size,
+                                uint8_t* encoded_buffer);
+
 }  // namespace brotli
 
 #endif  // BROTLI_ENC_ENCODE_PARALLEL_H_
@@ -2,9 +2,13 @@ package enc
 
 import (
 	"bytes"
+	"io/ioutil"
 	"log"
 	"strings"
 	"testing"
+	"testing/iotest"
+
+	"gopkg.in/kothar/brotli-go.v0/dec"
 )
 
 const (
@@ -103,3 +107,305 @@ func TestStreamEncode(T *testing.T) {
 		log.Printf("lgwin=%d, rounds=%d, output=%d (%.4f%% of input size)\n", params.Lgwin(), rounds, outputSize, float32(outputSize)*100.0/float32(inputSize))
 	}
 }
+
+func TestEncoderReuse(T *testing.T) {
+	inputs := [][]byte{
+		[]byte(strings.Repeat("The quick brown fox jumps over the lazy dog", 100000)),
+		[]byte("Hello Hello Hello, Hello Hello Hello"),
+		[]byte(strings.Repeat("Jackdaws love my big sphinx of quartz", 1000)),
+	}
+
+	for _, quality := range []int{2, 5, 9, 11} {
+		params := NewBrotliParams()
+		params.SetQuality(quality)
+		encoder := NewEncoder(params)
+
+		// Run through the inputs twice so every input follows a different one
+		for round := 0; round < 2; round++ {
+			for i, input := range inputs {
+				expected, err := CompressBuffer(params, input, nil)
+				if err != nil {
+					T.Fatal(err)
+				}
+
+				output, err := encoder.CompressBuffer(input, nil)
+				if err != nil {
+					T.Fatal(err)
+				}
+				if !bytes.Equal(output, expected) {
+					T.Fatalf("for quality %d, reused encoder didn't give same result as buffer compression for input %d", quality, i)
+				}
+			}
+		}
+
+		encoder.Close()
+		if _, err := encoder.CompressBuffer(inputs[0], nil); err == nil {
+			T.Error("Expected an error when using a closed encoder")
+		}
+	}
+}
+
+func TestWriterReset(T *testing.T) {
+	params := NewBrotliParams()
+	params.SetQuality(testQuality)
+
+	input1 := []byte(strings.Repeat("The quick brown fox jumps over the lazy dog", 100000))
+	input2 := []byte(strings.Repeat("Jackdaws love my big sphinx of quartz", 1000))
+
+	expected, err := CompressBuffer(params, input2, nil)
+	if err != nil {
+		T.Fatal(err)
+	}
+
+	// Abandon a stream part way through
+	writer := NewBrotliWriter(params, new(bytes.Buffer))
+	writer.Write(input1)
+
+	buffer := new(bytes.Buffer)
+	writer.Reset(buffer)
+	writer.Write(input2)
+	if err := writer.Flush(); err != nil {
+		T.Fatal(err)
+	}
+	writer.Reset(new(bytes.Buffer))
+
+	// Reuse the writer after it has been closed
+	for i := 0; i < 2; i++ {
+		buffer = new(bytes.Buffer)
+		writer.Reset(buffer)
+		writer.Write(input2)
+		if err := writer.Close(); err != nil {
+			T.Fatal(err)
+		}
+
+		if !bytes.Equal(buffer.Bytes(), expected) {
+			T.Fatalf("reset writer didn't give same result as buffer compression")
+		}
+	}
+}
+
+func TestWriterFinish(T *testing.T) {
+	params := NewBrotliParams()
+	params.SetQuality(testQuality)
+
+	input := []byte(strings.Repeat("Jackdaws love my big sphinx of quartz", 1000))
+	expected, err := CompressBuffer(params, input, nil)
+	if err != nil {
+		T.Fatal(err)
+	}
+
+	writer := NewBrotliWriter(params, nil)
+	defer writer.Close()
+	for i := 0; i < 2; i++ {
+		buffer := new(bytes.Buffer)
+		writer.Reset(buffer)
+		writer.Write(input)
+		if err := writer.Finish(); err != nil {
+			T.Fatal(err)
+		}
+		if !bytes.Equal(buffer.Bytes(), expected) {
+			T.Fatalf("finished writer didn't give same result as buffer compression")
+		}
+
+		if _, err := writer.Write(input); err != ErrWriterClosed {
+			T.Errorf("Expected ErrWriterClosed, got %v", err)
+		}
+		if err := writer.Finish(); err != ErrWriterClosed {
+			T.Errorf("Expected ErrWriterClosed, got %v", err)
+		}
+	}
+}
+
+func TestCompressBufferParallel(T *testing.T) {
+	input1 := []byte(strings.Repeat("The quick brown fox jumps over the lazy dog", 100000))
+
+	for _, lgwin := range []int{10, 15, 22} {
+		for _, quality := range []int{0, 5, 9, 11} {
+			params := NewBrotliParams()
+			params.SetQuality(quality)
+			params.SetLgwin(lgwin)
+			params.SetLgblock(16)
+
+			// The output must not depend on the number of workers
+			expected, err := CompressBufferParallel(params, input1, nil, 1)
+			if err != nil {
+				T.Fatal(err)
+			}
+			for _, workers := range []int{0, 3, 100} {
+				output, err := CompressBufferParallel(params, input1, make([]byte, len(input1)), workers)
+				if err != nil {
+					T.Fatal(err)
+				}
+				if !bytes.Equal(output, expected) {
+					T.Fatalf("for quality %d and lgwin %d, compression with %d workers didn't give same result as with 1 worker", quality, lgwin, workers)
+				}
+			}
+
+			decoded, err := dec.DecompressBuffer(expected, nil)
+			if err != nil || !bytes.Equal(decoded, input1) {
+				T.Fatalf("for quality %d and lgwin %d, parallel output doesn't decode to the input: %v", quality, lgwin, err)
+			}
+			log.Printf("q=%d, lgwin=%d, parallel output=%d (%.4f%% of input size)\n", quality, lgwin, len(expected), float32(len(expected))*100.0/float32(len(input1)))
+		}
+	}
+}
+
+func TestParallelWriter(T *testing.T) {
+	input1 := []byte(strings.Repeat("The quick brown fox jumps over the lazy dog", 100000))
+
+	for _, lgwin := range []int{10, 18} {
+		params := NewBrotliParams()
+		params.SetLgwin(lgwin)
+		params.SetLgblock(16)
+
+		for _, input := range [][]byte{nil, input1[:100], input1[:1<<16], input1} {
+			expected, err := CompressBufferParallel(params, input, nil, 1)
+			if err != nil {
+				T.Fatal(err)
+			}
+
+			// The output must not depend on how the input is written
+			for _, writeSize := range []int{1000, 1 << 16, len(input1)} {
+				var buffer bytes.Buffer
+				writer := NewParallelWriter(params, &buffer, 3)
+				for pos := 0; pos < len(input); pos += writeSize {
+					end := pos + writeSize
+					if end > len(input) {
+						end = len(input)
+					}
+					if _, err := writer.Write(input[pos:end]); err != nil {
+						T.Fatal(err)
+					}
+				}
+				if err := writer.Close(); err != nil {
+					T.Fatal(err)
+				}
+
+				if !bytes.Equal(buffer.Bytes(), expected) {
+					T.Fatalf("for lgwin %d, input size %d and write size %d, ParallelWriter didn't give same result as CompressBufferParallel", lgwin, len(input), writeSize)
+				}
+			}
+
+			decoded, err := dec.DecompressBuffer(expected, nil)
+			if err != nil || !bytes.Equal(decoded, input) {
+				T.Fatalf("for lgwin %d and input size %d, ParallelWriter output doesn't decode to the input: %v", lgwin, len(input), err)
+			}
+		}
+	}
+}
+
+func TestClosedErrors(T *testing.T) {
+	encoder := NewEncoder(nil)
+	encoder.Close()
+	if _, err := encoder.CompressBuffer([]byte("Hello"), nil); err != ErrEncoderClosed {
+		T.Errorf("Expected ErrEncoderClosed, got %v", err)
+	}
+
+	writer := NewBrotliWriter(nil, ioutil.Discard)
+	if err := writer.Close(); err != nil {
+		T.Fatal(err)
+	}
+	if _, err := writer.Write([]byte("Hello")); err != ErrWriterClosed {
+		T.Errorf("Expected ErrWriterClosed, got %v", err)
+	}
+	if err := writer.Flush(); err != ErrWriterClosed {
+		T.Errorf("Expected ErrWriterClosed, got %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		T.Errorf("Expected second Close to succeed, got %v", err)
+	}
+
+	parallelWriter := NewParallelWriter(nil, ioutil.Discard, 1)
+	if err := parallelWriter.Close(); err != nil {
+		T.Fatal(err)
+	}
+	if _, err := parallelWriter.Write([]byte("Hello")); err != ErrWriterClosed {
+		T.Errorf("Expected ErrWriterClosed, got %v", err)
+	}
+	if err := parallelWriter.Close(); err != nil {
+		T.Errorf("Expected second Close to succeed, got %v", err)
+	}
+}
+
+func TestEmptyInput(T *testing.T) {
+	for _, dict := range [][]byte{nil, []byte("dictionary")} {
+		output, err := CompressBufferDict(nil, nil, dict, nil)
+		if err != nil {
+			T.Fatal(err)
+		}
+		if len(output) == 0 {
+			T.Error("Expected an empty stream")
+		}
+	}
+	if _, err := CompressBuffer(nil, []byte{}, nil); err != nil {
+		T.Fatal(err)
+	}
+}
+
+func TestWriterReadFrom(T *testing.T) {
+	input1 := []byte(strings.Repeat("The quick brown fox jumps over the lazy dog", 100000))
+
+	params := NewBrotliParams()
+	params.SetQuality(5)
+	params.SetLgblock(16)
+
+	var expected bytes.Buffer
+	writer := NewBrotliWriter(params, &expected)
+	if _, err := writer.Write(input1); err != nil {
+		T.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		T.Fatal(err)
+	}
+
+	// The input is split into the same blocks as with Write
+	var output bytes.Buffer
+	writer = NewBrotliWriter(params, &output)
+	n, err := writer.ReadFrom(bytes.NewReader(input1))
+	if err != nil {
+		T.Fatal(err)
+	}
+	if n != int64(len(input1)) {
+		T.Errorf("ReadFrom consumed %d bytes, expected %d", n, len(input1))
+	}
+	if err := writer.Close(); err != nil {
+		T.Fatal(err)
+	}
+
+	if !bytes.Equal(output.Bytes(), expected.Bytes()) {
+		T.Error("ReadFrom didn't give same result as Write")
+	}
+}
+
+func TestCompressingReader(T *testing.T) {
+	input1 := []byte(strings.Repeat("The quick brown fox jumps over the lazy dog", 100000))
+
+	params := NewBrotliParams()
+	params.SetQuality(5)
+	params.SetLgblock(16)
+
+	for _, input := range [][]byte{nil, input1[:100], input1} {
+		var expected bytes.Buffer
+		writer := NewBrotliWriter(params, &expected)
+		if _, err := writer.Write(input); err != nil {
+			T.Fatal(err)
+		}
+		if err := writer.Close(); err != nil {
+			T.Fatal(err)
+		}
+
+		// The input is split into the same blocks as with BrotliWriter
+		reader := NewCompressingReader(params, iotest.HalfReader(bytes.NewReader(input)))
+		output, err := ioutil.ReadAll(iotest.OneByteReader(reader))
+		if err != nil {
+			T.Fatal(err)
+		}
+		if err := reader.Close(); err != nil {
+			T.Fatal(err)
+		}
+
+		if !bytes.Equal(output, expected.Bytes()) {
+			T.Errorf("for input size %d, CompressingReader didn't give same result as BrotliWriter", len(input))
+		}
+	}
+}
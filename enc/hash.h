@@ -660,6 +660,7 @@ class HashToBinaryTree {
         buckets_[i] = invalid_pos_;
       }
       size_t num_nodes = (position == 0 && is_last) ? bytes : window_mask_ + 1;
+      delete[] forest_;
       forest_ = new uint32_t[2 * num_nodes];
       need_init_ = false;
     }
@@ -905,6 +906,19 @@ struct Hashers {
     }
   }
 
+  // Marks the hash tables to be cleared on their next use.
+  void Reset() {
+    if (hash_h2) hash_h2->Reset();
+    if (hash_h3) hash_h3->Reset();
+    if (hash_h4) hash_h4->Reset();
+    if (hash_h5) hash_h5->Reset();
+    if (hash_h6) hash_h6->Reset();
+    if (hash_h7) hash_h7->Reset();
+    if (hash_h8) hash_h8->Reset();
+    if (hash_h9) hash_h9->Reset();
+    if (hash_h10) hash_h10->Reset();
+  }
+
   template<typename Hasher>
   void WarmupHash(const size_t size, const uint8_t* dict, Hasher* hasher) {
     hasher->Init();
@@ -0,0 +1,84 @@
+package enc
+
+import "fmt"
+
+// Range of the quality parameter
+const (
+	minQuality = 0
+	maxQuality = 11
+)
+
+// Option sets one of the parameters created by NewParams
+type Option func(*BrotliParams)
+
+// WithMode sets the operating mode of the compressor (GENERIC, TEXT or FONT)
+func WithMode(mode Mode) Option {
+	return func(p *BrotliParams) {
+		p.SetMode(mode)
+	}
+}
+
+// WithQuality sets the compression quality, from 0 to 11
+func WithQuality(quality int) Option {
+	return func(p *BrotliParams) {
+		p.SetQuality(quality)
+	}
+}
+
+// WithLgwin sets the base 2 logarithm of the sliding window size, from 10 to 24
+func WithLgwin(lgwin int) Option {
+	return func(p *BrotliParams) {
+		p.SetLgwin(lgwin)
+	}
+}
+
+// WithLgblock sets the base 2 logarithm of the maximum input block size, from
+// 16 to 24, or 0 to choose it based on the quality
+func WithLgblock(lgblock int) Option {
+	return func(p *BrotliParams) {
+		p.SetLgblock(lgblock)
+	}
+}
+
+// NewParams returns compressor parameters with the default settings, changed
+// by the given options. An error describing the problem is returned if any of
+// the resulting parameters are out of range.
+func NewParams(opts ...Option) (*BrotliParams, error) {
+	params := NewBrotliParams()
+	for _, opt := range opts {
+		opt(params)
+	}
+
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+	return params, nil
+}
+
+// Validate checks that the parameters are within the ranges supported by the
+// compressor, as the setters accept any value.
+func (p *BrotliParams) Validate() error {
+	switch mode := p.Mode(); mode {
+	case GENERIC, TEXT, FONT:
+	default:
+		return fmt.Errorf("brotli: invalid mode %d, must be GENERIC, TEXT or FONT", mode)
+	}
+	if quality := p.Quality(); quality < minQuality || quality > maxQuality {
+		return fmt.Errorf("brotli: invalid quality %d, must be from %d to %d", quality, minQuality, maxQuality)
+	}
+	if lgwin := p.Lgwin(); lgwin < minWindowBits || lgwin > maxWindowBits {
+		return fmt.Errorf("brotli: invalid lgwin %d, must be from %d to %d", lgwin, minWindowBits, maxWindowBits)
+	}
+	if lgblock := p.Lgblock(); lgblock != 0 && (lgblock < minInputBlockBits || lgblock > maxInputBlockBits) {
+		return fmt.Errorf("brotli: invalid lgblock %d, must be 0 or from %d to %d", lgblock, minInputBlockBits, maxInputBlockBits)
+	}
+	return nil
+}
+
+// Clone returns a copy of the parameters, which can be changed without
+// affecting the original. BrotliParams are not safe to change while other
+// goroutines use them, so shared parameters should be cloned first.
+func (p *BrotliParams) Clone() *BrotliParams {
+	clone := *p
+	return &clone
+}
@@ -0,0 +1,52 @@
+package enc
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewParams(T *testing.T) {
+	params, err := NewParams(WithQuality(5), WithLgwin(18), WithLgblock(16), WithMode(TEXT))
+	if err != nil {
+		T.Fatal(err)
+	}
+	if params.Quality() != 5 || params.Lgwin() != 18 || params.Lgblock() != 16 || params.Mode() != TEXT {
+		T.Errorf("Options were not applied: quality %d, lgwin %d, lgblock %d, mode %d",
+			params.Quality(), params.Lgwin(), params.Lgblock(), params.Mode())
+	}
+
+	defaults, err := NewParams()
+	if err != nil {
+		T.Fatal(err)
+	}
+	if *defaults != *NewBrotliParams() {
+		T.Error("Expected default parameters")
+	}
+
+	tests := []struct {
+		opt     Option
+		message string
+	}{
+		{WithQuality(-1), "invalid quality -1"},
+		{WithQuality(12), "invalid quality 12"},
+		{WithLgwin(9), "invalid lgwin 9"},
+		{WithLgwin(25), "invalid lgwin 25"},
+		{WithLgblock(15), "invalid lgblock 15"},
+		{WithLgblock(25), "invalid lgblock 25"},
+		{WithMode(Mode(3)), "invalid mode 3"},
+	}
+	for _, test := range tests {
+		if _, err := NewParams(test.opt); err == nil || !strings.Contains(err.Error(), test.message) {
+			T.Errorf("Expected error %q, got %v", test.message, err)
+		}
+	}
+}
+
+func TestParamsClone(T *testing.T) {
+	params, _ := NewParams(WithQuality(5))
+	clone := params.Clone()
+	clone.SetQuality(9)
+	if params.Quality() != 5 || clone.Quality() != 9 {
+		T.Error("Expected clone to be independent of the original")
+	}
+}
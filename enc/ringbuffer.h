@@ -74,6 +74,9 @@ class RingBuffer {
 
   void Reset() {
     pos_ = 0;
+    // Initialize the last two bytes and their copy to zero.
+    buffer_[-2] = buffer_[size_ - 2] = 0;
+    buffer_[-1] = buffer_[size_ - 1] = 0;
   }
 
   // Logical cursor position in the ring buffer.
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/kothar/brotli-go.v0/dec"
+	"gopkg.in/kothar/brotli-go.v0/enc"
+)
+
+// Set in the environment of "gbr bench-run" by bench, which is otherwise not a
+// subcommand
+const benchRunEnv = "GBR_BENCH_RUN"
+
+// How long each operation is repeated for, to get a stable speed for small files
+const benchTime = 200 * time.Millisecond
+
+// The result of compressing a file with one combination of parameters
+type benchResult struct {
+	File            string  `json:"file"`
+	Quality         int     `json:"quality"`
+	Lgwin           int     `json:"lgwin"`
+	Size            int     `json:"size"`
+	CompressedSize  int     `json:"compressedSize"`
+	Ratio           float64 `json:"ratio"`
+	CompressSpeed   float64 `json:"compressMBps"`
+	DecompressSpeed float64 `json:"decompressMBps"`
+	PeakMemory      int64   `json:"peakMemory,omitempty"`
+}
+
+// Runs "gbr bench", returning the exit status.
+//
+// Each measurement runs in a child process ("gbr bench-run"), so that the peak
+// memory reported for it includes the native encoder and decoder state, which
+// the Go runtime doesn't see, and isn't affected by earlier measurements.
+func bench(args []string) int {
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	lgwins := flags.String("lgwin", "16,18,20,22,24", "comma-separated window sizes to try (10-24)")
+	modeName := flags.String("mode", "generic", "compression mode: generic, text or font")
+	asJSON := flags.Bool("json", false, "write the results as JSON instead of a table")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s bench [flags] file ...\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	windows, err := parseLgwins(*lgwins)
+	if err == nil {
+		_, err = parseMode(*modeName)
+	}
+	if err != nil {
+		log.Print(err)
+		return 2
+	}
+
+	status := 0
+	var results []benchResult
+	table := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	if !*asJSON {
+		fmt.Fprintln(table, "file\tquality\tlgwin\tsize\tcompressed\tratio\tcompress MB/s\tdecompress MB/s\tpeak memory MB\t")
+	}
+	for _, name := range flags.Args() {
+		for _, lgwin := range windows {
+			for quality := minBenchQuality; quality <= maxBenchQuality; quality++ {
+				result, err := benchChild(name, quality, lgwin, *modeName)
+				if err != nil {
+					log.Printf("%s: quality %d, lgwin %d: %v", name, quality, lgwin, err)
+					status = 1
+					continue
+				}
+				if *asJSON {
+					results = append(results, result)
+					continue
+				}
+				fmt.Fprintf(table, "%s\t%d\t%d\t%d\t%d\t%.3f\t%.1f\t%.1f\t%s\t\n",
+					result.File, result.Quality, result.Lgwin, result.Size, result.CompressedSize,
+					result.Ratio, result.CompressSpeed, result.DecompressSpeed, formatMemory(result.PeakMemory))
+			}
+		}
+	}
+	table.Flush()
+
+	if *asJSON {
+		out, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			log.Print(err)
+			return 1
+		}
+		fmt.Printf("%s\n", out)
+	}
+	return status
+}
+
+// Range of qualities tried by "gbr bench"
+const (
+	minBenchQuality = 0
+	maxBenchQuality = 11
+)
+
+// Runs one measurement in a child process
+func benchChild(name string, quality, lgwin int, modeName string) (benchResult, error) {
+	var result benchResult
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(executable(), "bench-run", strconv.Itoa(quality), strconv.Itoa(lgwin), modeName, name)
+	cmd.Env = append(os.Environ(), benchRunEnv+"=1")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return result, fmt.Errorf("%s", strings.TrimPrefix(message, "gbr: "))
+		}
+		return result, err
+	}
+
+	if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+		return result, err
+	}
+	result.PeakMemory = peakMemory(cmd.ProcessState)
+	return result, nil
+}
+
+// Runs "gbr bench-run QUALITY LGWIN MODE FILE", which measures one combination
+// of parameters and writes the result to standard output as JSON
+func benchRun(args []string) int {
+	if len(args) != 4 {
+		log.Print("usage: bench-run quality lgwin mode file")
+		return 2
+	}
+	quality, err := strconv.Atoi(args[0])
+	if err != nil {
+		log.Print(err)
+		return 2
+	}
+	lgwin, err := strconv.Atoi(args[1])
+	if err != nil {
+		log.Print(err)
+		return 2
+	}
+	encMode, err := parseMode(args[2])
+	if err != nil {
+		log.Print(err)
+		return 2
+	}
+	params, err := enc.NewParams(enc.WithQuality(quality), enc.WithLgwin(lgwin), enc.WithMode(encMode))
+	if err != nil {
+		log.Print(err)
+		return 2
+	}
+
+	name := args[3]
+	input, err := ioutil.ReadFile(name)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+
+	var compressed []byte
+	compressTime, err := measure(func() (err error) {
+		compressed, err = enc.CompressBuffer(params, input, compressed[:0])
+		return err
+	})
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+
+	decompressed := make([]byte, 0, len(input))
+	decompressTime, err := measure(func() (err error) {
+		decompressed, err = dec.DecompressBuffer(compressed, decompressed[:0])
+		return err
+	})
+	if err != nil {
+		log.Printf("roundtrip failed: %v", err)
+		return 1
+	}
+	if !bytes.Equal(input, decompressed) {
+		log.Print("roundtrip failed: decompressed data does not match the input")
+		return 1
+	}
+
+	result := benchResult{
+		File:            name,
+		Quality:         quality,
+		Lgwin:           lgwin,
+		Size:            len(input),
+		CompressedSize:  len(compressed),
+		Ratio:           float64(len(input)) / float64(len(compressed)),
+		CompressSpeed:   megabytesPerSecond(len(input), compressTime),
+		DecompressSpeed: megabytesPerSecond(len(input), decompressTime),
+	}
+	if err := json.NewEncoder(os.Stdout).Encode(result); err != nil {
+		log.Print(err)
+		return 1
+	}
+	return 0
+}
+
+// Runs f repeatedly for at least benchTime, returning the average time taken
+func measure(f func() error) (time.Duration, error) {
+	start := time.Now()
+	runs := 0
+	for {
+		if err := f(); err != nil {
+			return 0, err
+		}
+		runs++
+		if elapsed := time.Since(start); elapsed >= benchTime {
+			return elapsed / time.Duration(runs), nil
+		}
+	}
+}
+
+func megabytesPerSecond(size int, d time.Duration) float64 {
+	return float64(size) / (1 << 20) / d.Seconds()
+}
+
+func formatMemory(bytes int64) string {
+	if bytes == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f", float64(bytes)/(1<<20))
+}
+
+// Parses a comma-separated list of window sizes
+func parseLgwins(list string) ([]int, error) {
+	var windows []int
+	for _, field := range strings.Split(list, ",") {
+		lgwin, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return nil, fmt.Errorf("invalid lgwin %q", field)
+		}
+		if _, err := enc.NewParams(enc.WithLgwin(lgwin)); err != nil {
+			return nil, err
+		}
+		windows = append(windows, lgwin)
+	}
+	return windows, nil
+}
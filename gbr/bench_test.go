@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseLgwins(T *testing.T) {
+	for list, expected := range map[string][]int{
+		"22":             {22},
+		"10,24":          {10, 24},
+		"16, 18 ,20":     {16, 18, 20},
+		"16,18,20,22,24": {16, 18, 20, 22, 24},
+	} {
+		if windows, err := parseLgwins(list); err != nil || !reflect.DeepEqual(windows, expected) {
+			T.Errorf("%q: expected %v, got %v, %v", list, expected, windows, err)
+		}
+	}
+
+	for list, expected := range map[string]string{
+		"":      "invalid lgwin",
+		"16,":   "invalid lgwin",
+		"16;18": "invalid lgwin",
+		"big":   "invalid lgwin",
+		"9":     "lgwin",
+		"25":    "lgwin",
+	} {
+		if _, err := parseLgwins(list); err == nil || !strings.Contains(err.Error(), expected) {
+			T.Errorf("%q: expected error containing %q, got %v", list, expected, err)
+		}
+	}
+}
+
+func TestBenchRun(T *testing.T) {
+	dir := newTestTree(T, map[string][]byte{"a.txt": testText})
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a.txt")
+
+	stdout, restore := redirectStdio(T, dir, nil)
+	status := benchRun([]string{"5", "18", "text", path})
+	restore()
+	if status != 0 {
+		T.Fatalf("Unexpected exit status %d", status)
+	}
+
+	data, err := ioutil.ReadFile(stdout.Name())
+	if err != nil {
+		T.Fatal(err)
+	}
+	var result benchResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		T.Fatalf("Invalid result %q: %v", data, err)
+	}
+	if result.File != path || result.Quality != 5 || result.Lgwin != 18 || result.Size != len(testText) {
+		T.Errorf("Unexpected result %+v", result)
+	}
+	if result.CompressedSize <= 0 || result.CompressedSize >= result.Size || result.CompressSpeed <= 0 || result.DecompressSpeed <= 0 {
+		T.Errorf("Unexpected measurements %+v", result)
+	}
+
+	for _, args := range [][]string{
+		{"5", "18", "text"},
+		{"q", "18", "text", path},
+		{"5", "18", "binary", path},
+		{"12", "18", "text", path},
+		{"5", "30", "text", path},
+	} {
+		if status := benchRun(args); status != 2 {
+			T.Errorf("%v: expected exit status 2, got %d", args, status)
+		}
+	}
+	if status := benchRun([]string{"5", "18", "text", filepath.Join(dir, "missing")}); status != 1 {
+		T.Errorf("Expected exit status 1 for a missing file, got %d", status)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"gopkg.in/kothar/brotli-go.v0/dec"
+)
+
+// What was found by decoding a compressed file
+type streamInfo struct {
+	compressed   int64
+	decompressed int64
+	lgwin        int
+}
+
+// Decodes each file without writing the output, for -t and -l, returning the
+// exit status. Errors from the decoder include the offset of the compressed
+// data where decoding failed.
+func checkFiles(names []string) int {
+	var table *tabwriter.Writer
+	if listMode {
+		table = tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(table, "compressed\tuncompressed\tratio\tlgwin\t name")
+	}
+
+	status := 0
+	for _, name := range names {
+		info, err := checkFile(name)
+		if err != nil {
+			log.Printf("%s: %v", name, err)
+			status = 1
+			continue
+		}
+		if listMode {
+			ratio := 0.0
+			if info.compressed > 0 {
+				ratio = float64(info.decompressed) / float64(info.compressed)
+			}
+			fmt.Fprintf(table, "%d\t%d\t%.3f\t%d\t %s\n", info.compressed, info.decompressed, ratio, info.lgwin, name)
+		}
+	}
+	if table != nil {
+		table.Flush()
+	}
+	return status
+}
+
+// Decodes a file, or standard input if name is "-", to a discard sink,
+// failing if there is more data after the end of the stream
+func checkFile(name string) (streamInfo, error) {
+	var info streamInfo
+	in := os.Stdin
+	if name != "-" {
+		var err error
+		if in, err = os.Open(name); err != nil {
+			return info, err
+		}
+		defer in.Close()
+	}
+
+	counted := &countingReader{reader: in}
+	header := &headerReader{reader: counted}
+	reader := dec.NewBrotliReader(header)
+	defer reader.Close()
+	decompressed, err := io.Copy(ioutil.Discard, reader)
+	if err != nil {
+		return info, err
+	}
+
+	// The decoder stops at the end of the stream, so anything after it, such
+	// as a second stream, would otherwise go unnoticed
+	end := reader.InputOffset()
+	if end == counted.n {
+		if _, err := io.ReadFull(counted, make([]byte, 1)); err != nil && err != io.EOF {
+			return info, err
+		}
+	}
+	if end < counted.n {
+		return info, fmt.Errorf("trailing data at offset %d", end)
+	}
+
+	info.compressed = end
+	info.decompressed = decompressed
+	info.lgwin = windowBits(header.first)
+	return info, nil
+}
+
+// Keeps the first byte read, which holds the window size of a stream
+type headerReader struct {
+	reader io.Reader
+	first  byte
+	read   bool
+}
+
+func (h *headerReader) Read(p []byte) (int, error) {
+	n, err := h.reader.Read(p)
+	if n > 0 && !h.read {
+		h.first = p[0]
+		h.read = true
+	}
+	return n, err
+}
+
+// Decodes the base 2 logarithm of the window size from the first byte of a
+// stream, which holds the WBITS field described in section 9.1 of RFC 7932
+func windowBits(header byte) int {
+	if header&1 == 0 {
+		return 16
+	}
+	if n := int(header>>1) & 7; n != 0 {
+		return 17 + n
+	}
+	if n := int(header>>4) & 7; n != 0 {
+		return 8 + n
+	}
+	return 17
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"gopkg.in/kothar/brotli-go.v0/enc"
+)
+
+func compressTestText(T *testing.T, lgwin int) []byte {
+	params, err := enc.NewParams(enc.WithQuality(5), enc.WithLgwin(lgwin))
+	if err != nil {
+		T.Fatal(err)
+	}
+	compressed, err := enc.CompressBuffer(params, testText, nil)
+	if err != nil {
+		T.Fatal(err)
+	}
+	return compressed
+}
+
+func TestCheckFile(T *testing.T) {
+	compressed := compressTestText(T, 22)
+	dir := newTestTree(T, map[string][]byte{
+		"valid.br":     compressed,
+		"garbage.br":   append(append([]byte{}, compressed...), "GARBAGE"...),
+		"twice.br":     bytes.Repeat(compressed, 2),
+		"truncated.br": compressed[:len(compressed)/2],
+		"empty.br":     nil,
+	})
+	defer os.RemoveAll(dir)
+
+	info, err := checkFile(filepath.Join(dir, "valid.br"))
+	if err != nil {
+		T.Fatal(err)
+	}
+	if info.compressed != int64(len(compressed)) || info.decompressed != int64(len(testText)) || info.lgwin != 22 {
+		T.Errorf("Unexpected stream info %+v for %d bytes compressed to %d", info, len(testText), len(compressed))
+	}
+
+	for name, expected := range map[string]string{
+		"garbage.br":   "trailing data at offset ",
+		"twice.br":     "trailing data at offset ",
+		"truncated.br": "unexpected EOF",
+		"empty.br":     "unexpected EOF",
+	} {
+		_, err := checkFile(filepath.Join(dir, name))
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			T.Errorf("%s: expected error containing %q, got %v", name, expected, err)
+			continue
+		}
+		if strings.HasPrefix(expected, "trailing") && !strings.HasSuffix(err.Error(), "offset "+strconv.Itoa(len(compressed))) {
+			T.Errorf("%s: expected trailing data at the end of the first stream, got %v", name, err)
+		}
+	}
+}
+
+func TestCheckFiles(T *testing.T) {
+	compressed := compressTestText(T, 22)
+	dir := newTestTree(T, map[string][]byte{
+		"a.br": compressed,
+		"b.br": append(append([]byte{}, compressed...), 0),
+	})
+	defer os.RemoveAll(dir)
+
+	if status := checkFiles([]string{filepath.Join(dir, "a.br")}); status != 0 {
+		T.Errorf("Expected exit status 0 for a valid file, got %d", status)
+	}
+	if status := checkFiles([]string{filepath.Join(dir, "a.br"), filepath.Join(dir, "b.br")}); status != 1 {
+		T.Errorf("Expected exit status 1 when a file is invalid, got %d", status)
+	}
+}
+
+func TestWindowBits(T *testing.T) {
+	for _, lgwin := range []int{10, 15, 16, 17, 18, 22, 24} {
+		compressed := compressTestText(T, lgwin)
+		if bits := windowBits(compressed[0]); bits != lgwin {
+			T.Errorf("Expected lgwin %d, got %d", lgwin, bits)
+		}
+	}
+
+	// Each form of the WBITS field in section 9.1 of RFC 7932
+	for header, lgwin := range map[byte]int{0x00: 16, 0x03: 18, 0x0f: 24, 0x01: 17, 0x21: 10, 0x71: 15} {
+		if bits := windowBits(header); bits != lgwin {
+			T.Errorf("For header %#x, expected lgwin %d, got %d", header, lgwin, bits)
+		}
+	}
+}
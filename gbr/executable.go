@@ -0,0 +1,15 @@
+//go:build go1.8
+// +build go1.8
+
+package main
+
+import "os"
+
+// Returns the path of the running gbr, for starting "gbr bench-run", which
+// os.Args[0] doesn't give if gbr was found on $PATH or run under another name
+func executable() string {
+	if path, err := os.Executable(); err == nil {
+		return path
+	}
+	return os.Args[0]
+}
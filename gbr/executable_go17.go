@@ -0,0 +1,11 @@
+//go:build !go1.8
+// +build !go1.8
+
+package main
+
+import "os"
+
+// os.Executable needs Go 1.8
+func executable() string {
+	return os.Args[0]
+}
@@ -1,69 +1,468 @@
-// A test application for applying brotli compression to files
+// Command gbr compresses and decompresses files with Brotli, in the same way
+// as gzip.
+//
+// Usage:
+//
+//	gbr [flags] [file ...]
+//
+// Each file is compressed to file.br, or decompressed from file.br to file,
+// and then removed unless -k is given. With -c, or when a file is "-",
+// the output is written to standard output. With no files, standard input is
+// compressed or decompressed to standard output.
+//
+// With -r, the files in directories are processed recursively, on several
+// goroutines. Files which don't shrink are left uncompressed, and a summary is
+// printed at the end.
+//
+// With -t, files are decoded without writing the output, exiting with a non-zero
+// status and the offset of the problem if any are invalid. -l does the same,
+// and lists the sizes, ratio and window size of each file.
+//
+// "gbr bench file ..." compresses each file at every quality and a range of
+// window sizes, reporting the compression ratio, speed and peak memory.
 package main
 
 import (
+	"bufio"
 	"flag"
-	"io/ioutil"
+	"fmt"
+	"io"
 	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"unicode/utf8"
 
 	"gopkg.in/kothar/brotli-go.v0/dec"
 	"gopkg.in/kothar/brotli-go.v0/enc"
 )
 
+// The suffix of compressed files
+const suffix = ".br"
+
+// How much of the input is checked for UTF-8 by -auto
+const autoSampleSize = 64 * 1024
+
 // Flags
 var (
-	compress   string
-	decompress string
+	decompress bool
+	toStdout   bool
+	keep       bool
+	force      bool
 	output     string
 	quality    int
+	mode       string
+	lgwin      int
+	lgblock    int
+	autoMode   bool
+	recursive  bool
+	include    globList
+	exclude    globList
+	minSize    int64
+	workers    int
+	testMode   bool
+	listMode   bool
 )
 
 func main() {
+	log.SetFlags(0)
+	log.SetPrefix("gbr: ")
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "bench":
+			os.Exit(bench(os.Args[2:]))
+		case "bench-run":
+			// Only run by bench, so a file of this name can still be compressed
+			if os.Getenv(benchRunEnv) != "" {
+				os.Exit(benchRun(os.Args[2:]))
+			}
+		}
+	}
+
 	// Configure flags
-	flag.StringVar(&compress, "c", "", "compress a file")
-	flag.StringVar(&decompress, "d", "", "decompress a file")
-	flag.StringVar(&output, "o", "", "output file")
-	flag.IntVar(&quality, "q", 9, "compression quality (1-11)")
+	flag.BoolVar(&decompress, "d", false, "decompress")
+	flag.BoolVar(&toStdout, "c", false, "write to standard output, keeping the input files")
+	flag.BoolVar(&keep, "k", false, "keep the input files")
+	flag.BoolVar(&force, "f", false, "overwrite existing output files, and write compressed data to a terminal")
+	flag.StringVar(&output, "o", "", "output file, keeping the input file")
+	flag.IntVar(&quality, "q", 9, "compression quality (0-11)")
+	flag.StringVar(&mode, "mode", "generic", "compression mode: generic, text or font")
+	flag.IntVar(&lgwin, "lgwin", 22, "base 2 logarithm of the sliding window size (10-24)")
+	flag.IntVar(&lgblock, "lgblock", 0, "base 2 logarithm of the input block size (16-24), or 0 to choose based on quality")
+	flag.BoolVar(&autoMode, "auto", false, "use text mode for input which is valid UTF-8")
+	flag.BoolVar(&recursive, "r", false, "process the files in directories recursively")
+	flag.Var(&include, "include", "with -r, only process files with names matching the glob pattern (may be repeated)")
+	flag.Var(&exclude, "exclude", "with -r, skip files with names matching the glob pattern (may be repeated)")
+	flag.Int64Var(&minSize, "min-size", 0, "with -r, skip files smaller than this many bytes")
+	flag.IntVar(&workers, "j", runtime.NumCPU(), "with -r, the number of files to process at once")
+	flag.BoolVar(&testMode, "t", false, "test the integrity of compressed files, without writing the output")
+	flag.BoolVar(&listMode, "l", false, "list the compressed and uncompressed size, ratio and window size of compressed files")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] [file ...]\n       %s bench [flags] file ...\n", os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
-	// Read input
-	var input string
-	if compress != "" {
-		input = compress
-	} else if decompress != "" {
-		input = decompress
-	} else {
-		log.Fatal("You must specify either compress or decompress")
+	encMode, err := parseMode(mode)
+	if err != nil {
+		log.Fatal(err)
 	}
-
-	inputData, err := ioutil.ReadFile(input)
+	params, err := enc.NewParams(enc.WithQuality(quality), enc.WithMode(encMode), enc.WithLgwin(lgwin), enc.WithLgblock(lgblock))
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	// Perform compression or decompression
-	var outputData []byte
-	if compress != "" {
-		params := enc.NewBrotliParams()
-		params.SetQuality(quality)
-		outputData, err = enc.CompressBuffer(params, inputData, nil)
-	} else if decompress != "" {
-		outputData, err = dec.DecompressBuffer(inputData, nil)
+	files := flag.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	if testMode || listMode {
+		if recursive || output != "" || toStdout {
+			log.Fatal("-t and -l cannot be used with -r, -c or -o")
+		}
+		os.Exit(checkFiles(files))
+	}
+	if output != "" && len(files) > 1 {
+		log.Fatal("-o can only be used with a single input file")
+	}
+
+	if recursive {
+		if output != "" || toStdout {
+			log.Fatal("-r cannot be used with -c or -o")
+		}
+		os.Exit(processTrees(files, params))
 	}
+
+	status := 0
+	for _, name := range files {
+		if _, err := processFile(name, params); err != nil {
+			log.Printf("%s: %v", name, err)
+			status = 1
+		}
+	}
+	os.Exit(status)
+}
+
+// The sizes of a file before and after processing
+type fileStats struct {
+	in, out int64
+	skipped bool // whether the file was left alone because it didn't shrink
+}
+
+// Compresses or decompresses a file, or standard input if name is "-"
+func processFile(name string, params *enc.BrotliParams) (fileStats, error) {
+	var stats fileStats
+	outName, err := outputName(name)
 	if err != nil {
-		log.Fatal(err)
+		return stats, err
 	}
 
-	// Write output
-	if output == "" {
-		if compress != "" {
-			output = input + ".bro"
-		} else if decompress != "" {
-			output = input + ".unbro"
+	in := os.Stdin
+	var info os.FileInfo
+	if name != "-" {
+		if in, err = os.Open(name); err != nil {
+			return stats, err
+		}
+		defer in.Close()
+
+		if info, err = in.Stat(); err != nil {
+			return stats, err
+		}
+		if !info.Mode().IsRegular() {
+			return stats, fmt.Errorf("not a regular file")
 		}
 	}
-	err = ioutil.WriteFile(output, outputData, 0666)
+	counted := &countingReader{reader: in}
+
+	if outName == "-" {
+		if !decompress && !force && isTerminal(os.Stdout) {
+			return stats, fmt.Errorf("compressed data not written to a terminal, use -f to force")
+		}
+		out := &countingWriter{writer: os.Stdout}
+		err = process(counted, out, params)
+		return fileStats{in: counted.n, out: out.n}, err
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if force {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	file, err := os.OpenFile(outName, flags, 0666)
+	if os.IsExist(err) {
+		return stats, fmt.Errorf("%s already exists, use -f to overwrite", outName)
+	}
 	if err != nil {
-		log.Fatal(err)
+		return stats, err
+	}
+
+	out := &countingWriter{writer: file}
+	err = process(counted, out, params)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && info != nil {
+		err = preserveAttributes(outName, info)
+	}
+	if err != nil {
+		os.Remove(outName)
+		return stats, err
+	}
+	stats = fileStats{in: counted.n, out: out.n}
+
+	// Precompressed files are only worth keeping if they are smaller
+	if recursive && !decompress && stats.out >= stats.in {
+		stats.skipped = true
+		return stats, os.Remove(outName)
+	}
+
+	// Only remove the input if the output name was derived from it
+	if name != "-" && output == "" && !keep {
+		in.Close()
+		return stats, os.Remove(name)
+	}
+	return stats, nil
+}
+
+// Gives the output file the permissions and modification time of the input
+func preserveAttributes(name string, info os.FileInfo) error {
+	if err := os.Chmod(name, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Chtimes(name, info.ModTime(), info.ModTime())
+}
+
+// Totals for the files processed with -r
+type summary struct {
+	mutex     sync.Mutex
+	processed int
+	skipped   int
+	failed    int
+	in, out   int64
+}
+
+func (s *summary) add(stats fileStats, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	switch {
+	case err != nil:
+		s.failed++
+	case stats.skipped:
+		s.skipped++
+	default:
+		s.processed++
+		s.in += stats.in
+		s.out += stats.out
+	}
+}
+
+func (s *summary) print() {
+	verb := "compressed"
+	if decompress {
+		verb = "decompressed"
+	}
+	message := fmt.Sprintf("%s %d files, %d -> %d bytes", verb, s.processed, s.in, s.out)
+	if !decompress && s.in > 0 {
+		message += fmt.Sprintf(", saved %d bytes (%.1f%%)", s.in-s.out, float64(s.in-s.out)*100/float64(s.in))
 	}
+	if s.skipped > 0 {
+		message += fmt.Sprintf(", skipped %d which did not shrink", s.skipped)
+	}
+	if s.failed > 0 {
+		message += fmt.Sprintf(", %d failed", s.failed)
+	}
+	log.Print(message)
+}
+
+// Processes the selected files in the directories on several goroutines,
+// returning the exit status
+func processTrees(roots []string, params *enc.BrotliParams) int {
+	var totals summary
+	paths := make(chan string)
+	var wg sync.WaitGroup
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				stats, err := processFile(path, params)
+				if err != nil {
+					log.Printf("%s: %v", path, err)
+				}
+				totals.add(stats, err)
+			}
+		}()
+	}
+
+	for _, root := range roots {
+		filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				log.Printf("%s: %v", path, err)
+				totals.add(fileStats{}, err)
+				return nil
+			}
+			if info.Mode().IsRegular() && selected(path, info) {
+				paths <- path
+			}
+			return nil
+		})
+	}
+	close(paths)
+	wg.Wait()
+
+	totals.print()
+	if totals.failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// Reports whether a file found by -r should be processed
+func selected(path string, info os.FileInfo) bool {
+	name := filepath.Base(path)
+	if strings.HasSuffix(name, suffix) != decompress {
+		return false
+	}
+	if len(include) > 0 && !include.matches(name) {
+		return false
+	}
+	return !exclude.matches(name) && info.Size() >= minSize
+}
+
+// A list of glob patterns, which can be given more than once on the command line
+type globList []string
+
+func (g *globList) String() string {
+	return strings.Join(*g, ",")
+}
+
+func (g *globList) Set(pattern string) error {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid pattern %q", pattern)
+	}
+	*g = append(*g, pattern)
+	return nil
+}
+
+// Reports whether the name matches any of the patterns
+func (g globList) matches(name string) bool {
+	for _, pattern := range g {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Counts the bytes read from a file
+type countingReader struct {
+	reader io.Reader
+	n      int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Counts the bytes written to a file
+type countingWriter struct {
+	writer io.Writer
+	n      int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.writer.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Works out where to write the output for a file, or "-" for standard output
+func outputName(name string) (string, error) {
+	switch {
+	case output != "":
+		return output, nil
+	case toStdout || name == "-":
+		return "-", nil
+	case !decompress:
+		if strings.HasSuffix(name, suffix) && !force {
+			return "", fmt.Errorf("already has %s suffix, use -f to compress it again", suffix)
+		}
+		return name + suffix, nil
+	case len(name) > len(suffix) && strings.HasSuffix(name, suffix):
+		return strings.TrimSuffix(name, suffix), nil
+	default:
+		return "", fmt.Errorf("unknown suffix, expected %s", suffix)
+	}
+}
+
+// Streams the input through the compressor or decompressor
+func process(in io.Reader, out io.Writer, params *enc.BrotliParams) error {
+	if decompress {
+		reader := dec.NewBrotliReader(in)
+		defer reader.Close()
+		_, err := io.Copy(out, reader)
+		return err
+	}
+
+	if autoMode {
+		in, params = detectMode(in, params)
+	}
+
+	// Hide out's Close method, which BrotliWriter would call
+	writer := enc.NewBrotliWriter(params, struct{ io.Writer }{out})
+	if _, err := io.Copy(writer, in); err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
+
+// Chooses text mode for input which starts with valid UTF-8, for --auto,
+// returning a Reader which gives the whole input
+func detectMode(in io.Reader, params *enc.BrotliParams) (io.Reader, *enc.BrotliParams) {
+	buffered := bufio.NewReaderSize(in, autoSampleSize)
+	if sample, _ := buffered.Peek(autoSampleSize); isText(sample) {
+		params = params.Clone()
+		params.SetMode(enc.TEXT)
+	}
+	return buffered, params
+}
+
+// Parses the name of a compression mode
+func parseMode(name string) (enc.Mode, error) {
+	switch strings.ToLower(name) {
+	case "generic":
+		return enc.GENERIC, nil
+	case "text":
+		return enc.TEXT, nil
+	case "font":
+		return enc.FONT, nil
+	}
+	return 0, fmt.Errorf("invalid mode %q, must be generic, text or font", name)
+}
+
+// Reports whether the start of the input is valid UTF-8
+func isText(sample []byte) bool {
+	// The sample may end part way through a character
+	start := len(sample) - 1
+	for start > 0 && len(sample)-start < utf8.UTFMax && !utf8.RuneStart(sample[start]) {
+		start--
+	}
+	if start >= 0 && !utf8.FullRune(sample[start:]) {
+		sample = sample[:start]
+	}
+	return utf8.Valid(sample)
+}
+
+// Reports whether the file is a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
 }
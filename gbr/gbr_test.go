@@ -0,0 +1,434 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"gopkg.in/kothar/brotli-go.v0/dec"
+	"gopkg.in/kothar/brotli-go.v0/enc"
+)
+
+var testText = []byte(strings.Repeat("The quick brown fox jumps over the lazy dog. ", 1000))
+
+// Sets the flags to their defaults, with -r
+func resetFlags() {
+	decompress, toStdout, keep, force = false, false, false, false
+	output = ""
+	recursive = true
+	include, exclude = nil, nil
+	minSize = 0
+	workers = 2
+	autoMode, testMode, listMode = false, false, false
+}
+
+func testParams(T *testing.T) *enc.BrotliParams {
+	params, err := enc.NewParams(enc.WithQuality(5))
+	if err != nil {
+		T.Fatal(err)
+	}
+	return params
+}
+
+// Creates a directory of files, returning its path
+func newTestTree(T *testing.T, files map[string][]byte) string {
+	log.SetOutput(ioutil.Discard)
+	dir, err := ioutil.TempDir("", "gbr")
+	if err != nil {
+		T.Fatal(err)
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			T.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			T.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// Checks that a compressed file decodes to the expected data
+func checkCompressed(T *testing.T, path string, expected []byte) {
+	compressed, err := ioutil.ReadFile(path)
+	if err != nil {
+		T.Fatal(err)
+	}
+	checkDecoded(T, compressed, expected)
+}
+
+func checkDecoded(T *testing.T, compressed, expected []byte) {
+	decoded, err := dec.DecompressBuffer(compressed, nil)
+	if err != nil || !bytes.Equal(decoded, expected) {
+		T.Errorf("Compressed data does not decode to the original: %v", err)
+	}
+}
+
+func TestRecursive(T *testing.T) {
+	defer resetFlags()
+	for _, keepInputs := range []bool{false, true} {
+		resetFlags()
+		keep = keepInputs
+		dir := newTestTree(T, map[string][]byte{
+			"a.txt":     testText,
+			"sub/b.txt": testText,
+		})
+		defer os.RemoveAll(dir)
+
+		if status := processTrees([]string{dir}, testParams(T)); status != 0 {
+			T.Fatalf("Unexpected exit status %d", status)
+		}
+		for _, name := range []string{"a.txt", "sub/b.txt"} {
+			path := filepath.Join(dir, name)
+			checkCompressed(T, path+suffix, testText)
+			if exists(path) != keepInputs {
+				T.Errorf("keep %v: expected %s to exist: %v", keepInputs, name, keepInputs)
+			}
+		}
+	}
+}
+
+func TestRecursiveFilters(T *testing.T) {
+	defer resetFlags()
+	resetFlags()
+	dir := newTestTree(T, map[string][]byte{
+		"a.txt":    testText,
+		"b.css":    testText,
+		"c.js":     testText,
+		"d.txt":    []byte("small"),
+		"e.txt.br": testText,
+	})
+	defer os.RemoveAll(dir)
+
+	include.Set("*.txt")
+	include.Set("*.css")
+	exclude.Set("b.*")
+	minSize = 100
+	processTrees([]string{dir}, testParams(T))
+
+	for name, compressed := range map[string]bool{"a.txt": true, "b.css": false, "c.js": false, "d.txt": false, "e.txt.br": false} {
+		if exists(filepath.Join(dir, name+suffix)) != compressed {
+			T.Errorf("Expected %s to be compressed: %v", name, compressed)
+		}
+	}
+}
+
+func TestRecursiveSkip(T *testing.T) {
+	defer resetFlags()
+	resetFlags()
+	random := make([]byte, 10000)
+	rand.New(rand.NewSource(1)).Read(random)
+	dir := newTestTree(T, map[string][]byte{"random.bin": random})
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "random.bin")
+	modTime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	os.Chtimes(path, modTime, modTime)
+
+	if status := processTrees([]string{dir}, testParams(T)); status != 0 {
+		T.Fatalf("Unexpected exit status %d", status)
+	}
+	if exists(path + suffix) {
+		T.Error("Expected compressed file which did not shrink to be removed")
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil || !bytes.Equal(data, random) {
+		T.Fatalf("Expected input to be left alone: %v", err)
+	}
+	if info, _ := os.Stat(path); !info.ModTime().Equal(modTime) {
+		T.Errorf("Expected input modification time to be unchanged, got %v", info.ModTime())
+	}
+}
+
+func TestPreserveAttributes(T *testing.T) {
+	defer resetFlags()
+	resetFlags()
+	recursive = false
+	dir := newTestTree(T, map[string][]byte{"a.txt": testText})
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "a.txt")
+	modTime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := os.Chmod(path, 0640); err != nil {
+		T.Fatal(err)
+	}
+	os.Chtimes(path, modTime, modTime)
+
+	if _, err := processFile(path, testParams(T)); err != nil {
+		T.Fatal(err)
+	}
+	info, err := os.Stat(path + suffix)
+	if err != nil {
+		T.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 || !info.ModTime().Equal(modTime) {
+		T.Errorf("Expected mode 0640 and time %v, got %v and %v", modTime, info.Mode().Perm(), info.ModTime())
+	}
+	if exists(path) {
+		T.Error("Expected input to be removed")
+	}
+}
+
+func TestNoOverwrite(T *testing.T) {
+	defer resetFlags()
+	resetFlags()
+	recursive = false
+	dir := newTestTree(T, map[string][]byte{
+		"a.txt":    testText,
+		"a.txt.br": []byte("existing"),
+	})
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a.txt")
+
+	if _, err := processFile(path, testParams(T)); err == nil || !strings.Contains(err.Error(), "already exists") {
+		T.Errorf("Expected error for existing output, got %v", err)
+	}
+	if existing, _ := ioutil.ReadFile(path + suffix); string(existing) != "existing" || !exists(path) {
+		T.Error("Expected existing output and input to be left alone")
+	}
+
+	// Or with -r
+	recursive = true
+	if status := processTrees([]string{dir}, testParams(T)); status == 0 {
+		T.Error("Expected non-zero exit status for existing output")
+	}
+	if existing, _ := ioutil.ReadFile(path + suffix); string(existing) != "existing" || !exists(path) {
+		T.Error("Expected existing output and input to be left alone with -r")
+	}
+
+	force = true
+	if _, err := processFile(path, testParams(T)); err != nil {
+		T.Fatal(err)
+	}
+	checkCompressed(T, path+suffix, testText)
+}
+
+func TestOutputName(T *testing.T) {
+	defer resetFlags()
+	tests := []struct {
+		name                              string
+		decompress, toStdout, force, keep bool
+		output                            string
+		expected                          string
+		err                               string
+	}{
+		{name: "a.txt", expected: "a.txt.br"},
+		{name: "a.txt", keep: true, expected: "a.txt.br"},
+		{name: "a.txt.br", err: "already has .br suffix"},
+		{name: "a.txt.br", force: true, expected: "a.txt.br.br"},
+		{name: "a.txt", toStdout: true, expected: "-"},
+		{name: "a.txt.br", toStdout: true, expected: "-"},
+		{name: "-", expected: "-"},
+		{name: "a.txt", output: "b.br", expected: "b.br"},
+		{name: "a.txt", output: "b.br", toStdout: true, expected: "b.br"},
+		{name: "a.txt.br", decompress: true, expected: "a.txt"},
+		{name: "a.txt.br", decompress: true, keep: true, expected: "a.txt"},
+		{name: "dir/a.txt.br", decompress: true, expected: "dir/a.txt"},
+		{name: "a.txt", decompress: true, err: "unknown suffix"},
+		{name: ".br", decompress: true, err: "unknown suffix"},
+		{name: "a.txt", decompress: true, force: true, err: "unknown suffix"},
+		{name: "a.txt", decompress: true, toStdout: true, expected: "-"},
+		{name: "a.txt", decompress: true, output: "b", expected: "b"},
+	}
+	for _, test := range tests {
+		resetFlags()
+		decompress, toStdout, force, keep, output = test.decompress, test.toStdout, test.force, test.keep, test.output
+		name, err := outputName(test.name)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				T.Errorf("%+v: expected error containing %q, got %q, %v", test, test.err, name, err)
+			}
+			continue
+		}
+		if err != nil || name != test.expected {
+			T.Errorf("%+v: expected %q, got %q, %v", test, test.expected, name, err)
+		}
+	}
+}
+
+func TestProcess(T *testing.T) {
+	defer resetFlags()
+	resetFlags()
+
+	var compressed, decompressed bytes.Buffer
+	if err := process(iotest.HalfReader(bytes.NewReader(testText)), &compressed, testParams(T)); err != nil {
+		T.Fatal(err)
+	}
+	checkDecoded(T, compressed.Bytes(), testText)
+
+	decompress = true
+	if err := process(iotest.HalfReader(bytes.NewReader(compressed.Bytes())), &decompressed, testParams(T)); err != nil {
+		T.Fatal(err)
+	}
+	if !bytes.Equal(decompressed.Bytes(), testText) {
+		T.Error("Decompressed data does not match the input")
+	}
+
+	if err := process(bytes.NewReader(compressed.Bytes()[:compressed.Len()/2]), ioutil.Discard, testParams(T)); err == nil {
+		T.Error("Expected an error decompressing truncated input")
+	}
+}
+
+// Replaces standard input and output with files for the duration of a test
+func redirectStdio(T *testing.T, dir string, input []byte) (stdout *os.File, restore func()) {
+	stdinName := filepath.Join(dir, "stdin")
+	if err := ioutil.WriteFile(stdinName, input, 0644); err != nil {
+		T.Fatal(err)
+	}
+	stdin, err := os.Open(stdinName)
+	if err != nil {
+		T.Fatal(err)
+	}
+	stdout, err = os.Create(filepath.Join(dir, "stdout"))
+	if err != nil {
+		T.Fatal(err)
+	}
+
+	savedStdin, savedStdout := os.Stdin, os.Stdout
+	os.Stdin, os.Stdout = stdin, stdout
+	return stdout, func() {
+		os.Stdin, os.Stdout = savedStdin, savedStdout
+		stdin.Close()
+		stdout.Close()
+	}
+}
+
+func TestProcessFileStdio(T *testing.T) {
+	defer resetFlags()
+	resetFlags()
+	recursive = false
+	dir := newTestTree(T, map[string][]byte{"a.txt": testText})
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a.txt")
+
+	// Standard input to standard output
+	stdout, restore := redirectStdio(T, dir, testText)
+	stats, err := processFile("-", testParams(T))
+	restore()
+	if err != nil {
+		T.Fatal(err)
+	}
+	if stats.in != int64(len(testText)) {
+		T.Errorf("Expected %d bytes read, got %d", len(testText), stats.in)
+	}
+	checkCompressed(T, stdout.Name(), testText)
+
+	// -c writes to standard output and keeps the input
+	toStdout = true
+	stdout, restore = redirectStdio(T, dir, nil)
+	_, err = processFile(path, testParams(T))
+	restore()
+	if err != nil {
+		T.Fatal(err)
+	}
+	checkCompressed(T, stdout.Name(), testText)
+	if !exists(path) || exists(path+suffix) {
+		T.Error("Expected -c to keep the input and not write a .br file")
+	}
+
+	// -o writes to the named file and keeps the input
+	toStdout = false
+	output = filepath.Join(dir, "out.br")
+	if _, err := processFile(path, testParams(T)); err != nil {
+		T.Fatal(err)
+	}
+	checkCompressed(T, output, testText)
+	if !exists(path) {
+		T.Error("Expected -o to keep the input")
+	}
+
+	// -d removes the .br file after writing the output
+	output = ""
+	decompress = true
+	if err := os.Rename(filepath.Join(dir, "out.br"), path+suffix); err != nil {
+		T.Fatal(err)
+	}
+	if _, err := processFile(path+suffix, testParams(T)); err == nil || !strings.Contains(err.Error(), "already exists") {
+		T.Errorf("Expected error for existing output, got %v", err)
+	}
+	os.Remove(path)
+	if _, err := processFile(path+suffix, testParams(T)); err != nil {
+		T.Fatal(err)
+	}
+	if data, err := ioutil.ReadFile(path); err != nil || !bytes.Equal(data, testText) {
+		T.Errorf("Decompressed file does not match the input: %v", err)
+	}
+	if exists(path + suffix) {
+		T.Error("Expected the .br file to be removed")
+	}
+}
+
+func TestParseMode(T *testing.T) {
+	for name, expected := range map[string]enc.Mode{"generic": enc.GENERIC, "text": enc.TEXT, "font": enc.FONT, "TEXT": enc.TEXT} {
+		if mode, err := parseMode(name); err != nil || mode != expected {
+			T.Errorf("%s: expected mode %v, got %v, %v", name, expected, mode, err)
+		}
+	}
+	for _, name := range []string{"", "binary", "texts"} {
+		if _, err := parseMode(name); err == nil || !strings.Contains(err.Error(), "invalid mode") {
+			T.Errorf("%q: expected invalid mode error, got %v", name, err)
+		}
+	}
+}
+
+func TestIsText(T *testing.T) {
+	euro := "€"
+	emoji := "\U0001f600"
+	for sample, expected := range map[string]bool{
+		"":                                  true,
+		"plain text":                        true,
+		"café " + euro + emoji:              true,
+		"café"[:4]:                          true, // split two byte character
+		"price " + euro[:2]:                 true, // split three byte character
+		"smile " + emoji[:3]:                true, // split four byte character
+		"bad \xff byte":                     false,
+		"stray continuation \x80":           false,
+		"split then more " + euro[:2] + "x": false,
+		"\x00\x01\x02\xfe\xff":              false,
+	} {
+		if isText([]byte(sample)) != expected {
+			T.Errorf("%q: expected isText %v", sample, expected)
+		}
+	}
+}
+
+func TestDetectMode(T *testing.T) {
+	params := testParams(T)
+	text := []byte(strings.Repeat("Die Blütezeit der Apfelbäume ist im Frühling. ", 2000))
+	for _, test := range []struct {
+		input    []byte
+		expected enc.Mode
+	}{
+		{nil, enc.TEXT},
+		{text, enc.TEXT},
+		{append([]byte{0xff}, text...), enc.GENERIC},
+		// Invalid UTF-8 after the sample isn't seen
+		{append(append([]byte{}, text[:autoSampleSize]...), 0xff), enc.TEXT},
+		// The sample may end part way through a character
+		{append(bytes.Repeat([]byte("a"), autoSampleSize-1), "ü"...), enc.TEXT},
+	} {
+		in, modeParams := detectMode(iotest.HalfReader(bytes.NewReader(test.input)), params)
+		if modeParams.Mode() != test.expected {
+			T.Errorf("For %d bytes, expected mode %v, got %v", len(test.input), test.expected, modeParams.Mode())
+		}
+		if data, err := ioutil.ReadAll(in); err != nil || !bytes.Equal(data, test.input) {
+			T.Errorf("For %d bytes, expected the whole input to be read: %v", len(test.input), err)
+		}
+	}
+	if params.Mode() != enc.GENERIC {
+		T.Error("Expected the parameters passed in to be unchanged")
+	}
+}
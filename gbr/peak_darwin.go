@@ -0,0 +1,15 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// Returns the peak resident memory of a finished process in bytes, or 0 if it
+// isn't known
+func peakMemory(state *os.ProcessState) int64 {
+	if usage, ok := state.SysUsage().(*syscall.Rusage); ok {
+		return int64(usage.Maxrss)
+	}
+	return 0
+}
@@ -0,0 +1,11 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package main
+
+import "os"
+
+// Returns 0, as the peak memory of a process isn't known on this platform
+func peakMemory(state *os.ProcessState) int64 {
+	return 0
+}
@@ -0,0 +1,19 @@
+//go:build dragonfly || freebsd || linux || netbsd || openbsd
+// +build dragonfly freebsd linux netbsd openbsd
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// Returns the peak resident memory of a finished process in bytes, or 0 if it
+// isn't known
+func peakMemory(state *os.ProcessState) int64 {
+	if usage, ok := state.SysUsage().(*syscall.Rusage); ok {
+		// Reported in kilobytes
+		return int64(usage.Maxrss) * 1024
+	}
+	return 0
+}